@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+// eventSinks получатели событий outbox из EVENT_SINKS через запятую, по умолчанию webhook.
+// available - получатели, которые можно включить (nats есть только при заданном NATS_URL)
+func eventSinks(names string, available map[string]usecase.EventSink) ([]usecase.EventSink, error) {
+	if strings.TrimSpace(names) == "" {
+		names = "webhook"
+	}
+
+	var sinks []usecase.EventSink
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		sink, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or not configured sink %q", name)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// loadNotificationTemplates yaml файл из NOTIFICATION_TEMPLATES, без него - шаблоны по умолчанию
+func loadNotificationTemplates(path string) (usecase.NotificationTemplates, error) {
+	var result usecase.NotificationTemplates
+	if path == "" {
+		return result, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, err
+	}
+	return result, yaml.Unmarshal(data, &result)
+}
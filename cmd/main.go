@@ -1,14 +1,20 @@
 package main
 
 import (
+	"errors"
 	"github.com/ashurov-imomali/pr-service/internal/api"
 	"github.com/ashurov-imomali/pr-service/internal/repository"
 	"github.com/ashurov-imomali/pr-service/internal/server"
 	"github.com/ashurov-imomali/pr-service/internal/usecase"
 	"github.com/ashurov-imomali/pr-service/migration"
+	"github.com/ashurov-imomali/pr-service/pkg/broker"
+	"github.com/ashurov-imomali/pr-service/pkg/clock"
 	"github.com/ashurov-imomali/pr-service/pkg/db"
 	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"github.com/ashurov-imomali/pr-service/pkg/metrics"
+	"github.com/nats-io/nats.go"
 	"golang.org/x/net/context"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
@@ -36,28 +42,160 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	m := metrics.New()
+	if err := pgConnection.Use(m.GormPlugin()); err != nil {
+		log.Fatalf("Failed to initialize db metrics: %v", err)
+	}
+
 	r := repository.New(pgConnection)
 
-	prs := usecase.NewPRService(r, log)
-	us := usecase.NewUserService(r, log)
+	subs := usecase.NewSubscriptionService(r, log)
+	prs := usecase.NewPRService(r, log, m)
+	us := usecase.NewUserService(r, log, m)
 	ts := usecase.NewTeamService(r, log)
 	ss := usecase.NewStatService(r, log)
+	oss := usecase.NewOrgSyncService(r, log, m)
+	scs := usecase.NewScimService(r, us, ts, log)
+	ms := usecase.NewMetricsService(r, log, m)
+	whs := usecase.NewWebhookService(r, prs, log)
+	ns := usecase.NewNotificationService(r, log)
+	stream := usecase.NewEventStream(r, log)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sync":
+			runSync(log, oss, os.Args[2:])
+			return
+		case "rebuild-stats":
+			runRebuildStats(log, ss)
+			return
+		case "report":
+			runReport(log, ss, os.Args[2:])
+			return
+		}
+	}
+
+	h := api.New(prs, us, ts, ss, oss, scs, whs, subs, ns, stream,
+		os.Getenv("SCIM_TOKEN"), os.Getenv("GITHUB_WEBHOOK_SECRET"), os.Getenv("GITLAB_WEBHOOK_TOKEN"),
+		os.Getenv("ADMIN_TOKEN"))
+
+	metricsInterval := 30 * time.Second
+	if v := os.Getenv("METRICS_REFRESH_INTERVAL"); v != "" {
+		if metricsInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid METRICS_REFRESH_INTERVAL: %v", err)
+		}
+	}
+
+	dispatchInterval := 5 * time.Second
+	if v := os.Getenv("WEBHOOK_DISPATCH_INTERVAL"); v != "" {
+		if dispatchInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid WEBHOOK_DISPATCH_INTERVAL: %v", err)
+		}
+	}
+
+	relayInterval := time.Second
+	if v := os.Getenv("OUTBOX_RELAY_INTERVAL"); v != "" {
+		if relayInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid OUTBOX_RELAY_INTERVAL: %v", err)
+		}
+	}
+
+	slaInterval := time.Minute
+	if v := os.Getenv("SLA_CHECK_INTERVAL"); v != "" {
+		if slaInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid SLA_CHECK_INTERVAL: %v", err)
+		}
+	}
+
+	natsPrefix := os.Getenv("NATS_SUBJECT_PREFIX")
+	if natsPrefix == "" {
+		natsPrefix = "pr-service"
+	}
+	var nc *nats.Conn
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		if nc, err = broker.New(natsURL, "pr-service"); err != nil {
+			log.Fatalf("Failed to connect to NATS: %v", err)
+		}
+		if _, err := usecase.NewCommandConsumer(nc, prs, natsPrefix, log).Subscribe(); err != nil {
+			log.Fatalf("Failed to subscribe to NATS commands: %v", err)
+		}
+	}
+
+	templates, err := loadNotificationTemplates(os.Getenv("NOTIFICATION_TEMPLATES"))
+	if err != nil {
+		log.Fatalf("Invalid NOTIFICATION_TEMPLATES: %v", err)
+	}
+	chat, err := usecase.NewChatNotifier(r, log, templates.Chat)
+	if err != nil {
+		log.Fatalf("Invalid chat templates: %v", err)
+	}
+
+	available := map[string]usecase.EventSink{
+		"webhook": subs,
+		"log":     usecase.NewLogSink(log),
+		"chat":    chat,
+	}
+	if nc != nil {
+		available["nats"] = usecase.NewNATSSink(nc, natsPrefix)
+	}
+
+	var email *usecase.EmailNotifier
+	digestAt := os.Getenv("EMAIL_DIGEST_AT")
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		smtpFrom := os.Getenv("SMTP_FROM")
+		if smtpFrom == "" {
+			smtpFrom = "pr-service@localhost"
+		}
+		if digestAt != "" {
+			if _, err := time.Parse("15:04", digestAt); err != nil {
+				log.Fatalf("Invalid EMAIL_DIGEST_AT: %v", err)
+			}
+		}
+		email, err = usecase.NewEmailNotifier(r, log, usecase.SMTPConfig{
+			Addr:     smtpAddr,
+			From:     smtpFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, templates.Email, templates.Digest)
+		if err != nil {
+			log.Fatalf("Invalid email templates: %v", err)
+		}
+		available["email"] = email
+	}
+	sinks, err := eventSinks(os.Getenv("EVENT_SINKS"), available)
+	if err != nil {
+		log.Fatalf("Invalid EVENT_SINKS: %v", err)
+	}
+	relay := usecase.NewEventRelay(r, log, sinks...)
 
-	h := api.New(prs, us, ts, ss)
+	appCtx, appCancel := context.WithCancel(context.Background())
+	go ms.Run(appCtx, metricsInterval)
+	go subs.Run(appCtx, dispatchInterval)
+	go chat.Run(appCtx, dispatchInterval)
+	if email != nil {
+		go email.Run(appCtx, dispatchInterval, digestAt)
+	}
+	go relay.Run(appCtx, relayInterval)
+	go stream.Run(appCtx, relayInterval)
+	go usecase.NewSLAScheduler(r, prs, log, clock.Real{}).Run(appCtx, slaInterval)
 
-	srv := server.NewServer(":"+port, h)
+	srv := server.NewServer(":"+port, h, m)
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
 	go func() {
 		log.Infof("Server starting on port %s", port)
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
 
 	<-stop
 	log.Infof("%s", "Shutting down server...")
+	appCancel()
+	if nc != nil {
+		_ = nc.Drain()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// runReport печатает недельный отчет: pr-service report [-team a,b] [-to 2025-01-13] [-format md|html] [-out report.md]
+func runReport(log logger.Logger, ss *usecase.StatService, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	teams := fs.String("team", "", "comma separated team names, by default all teams")
+	from := fs.String("from", "", "period start (2006-01-02), by default a week before -to")
+	to := fs.String("to", "", "period end (2006-01-02), by default now")
+	format := fs.String("format", "md", "md or html")
+	out := fs.String("out", "", "output file, by default stdout")
+	_ = fs.Parse(args)
+
+	parseDate := func(value string) *time.Time {
+		if value == "" {
+			return nil
+		}
+		t, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			log.Fatalf("Invalid date %q: %v", value, err)
+		}
+		return &t
+	}
+	filter := models.StatFilter{From: parseDate(*from), To: parseDate(*to)}
+
+	write := usecase.WriteReportMarkdown
+	switch *format {
+	case "md":
+	case "html":
+		write = usecase.WriteReportHTML
+	default:
+		log.Fatalf("Invalid format %q", *format)
+	}
+
+	var teamNames []string
+	if *teams != "" {
+		teamNames = strings.Split(*teams, ",")
+	}
+
+	report, _, wErr := ss.GetReport(teamNames, filter)
+	if wErr != nil {
+		log.Fatalf("Report failed: %s %s", wErr.Code, wErr.Message)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w, report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"time"
+)
+
+// runRebuildStats пересчитывает агрегаты статистики: pr-service rebuild-stats
+func runRebuildStats(log logger.Logger, ss *usecase.StatService) {
+	start := time.Now()
+	if _, wErr := ss.RebuildStats(); wErr != nil {
+		log.Fatalf("Rebuild failed: %s %s", wErr.Code, wErr.Message)
+	}
+	log.Infof("Stats rebuilt in %s", time.Since(start))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runSync синхронизирует команды и участников из файла: pr-service sync -file org.yaml [-dry-run] [-prune]
+func runSync(log logger.Logger, oss *usecase.OrgSyncService, args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	file := fs.String("file", "", "path to org chart (yaml or csv)")
+	format := fs.String("format", "", "yaml or csv, by default detected from file extension")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	prune := fs.Bool("prune", false, "deactivate users missing from the file, also in teams the file does not mention")
+	_ = fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalf("%s", "-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("Failed to read org chart: %v", err)
+	}
+
+	plan, _, wErr := oss.Import(data, *format, *dryRun, *prune)
+	if wErr != nil {
+		log.Fatalf("Sync failed: %s %s", wErr.Code, wErr.Message)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(plan)
+}
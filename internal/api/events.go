@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const streamHeartbeat = 15 * time.Second
+
+// streamEvents SSE поток событий. Фильтры team_name, user_id, pull_request_id; заголовок Last-Event-ID
+// (или параметр last_event_id) - продолжить после этого события
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := usecase.StreamFilter{
+		TeamName:      query.Get("team_name"),
+		UserID:        query.Get("user_id"),
+		PullRequestID: query.Get("pull_request_id"),
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	var lastEventID int64
+	if lastID != "" {
+		var err error
+		if lastEventID, err = strconv.ParseInt(lastID, 10, 64); err != nil || lastEventID < 0 {
+			writeError(w, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// поток живет дольше WriteTimeout сервера
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, &usecase.Error{Code: "STREAMING_UNSUPPORTED"})
+		return
+	}
+
+	sub, missed, complete := h.stream.Subscribe(filter, lastEventID)
+	defer h.stream.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		// часть пропущенных событий уже не в истории, клиенту стоит перечитать состояние (/stats и т.п.)
+		fmt.Fprint(w, "event: stream.reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			if e.ID <= lastEventID {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e models.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
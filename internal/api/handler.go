@@ -4,23 +4,49 @@ import (
 	"encoding/json"
 	"github.com/ashurov-imomali/pr-service/internal/models"
 	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 type Handler struct {
-	prs *usecase.PRService
-	us  *usecase.UserService
-	ts  *usecase.TeamService
-	ss  *usecase.StatService
+	prs    *usecase.PRService
+	us     *usecase.UserService
+	ts     *usecase.TeamService
+	ss     *usecase.StatService
+	oss    *usecase.OrgSyncService
+	scs    *usecase.ScimService
+	whs    *usecase.WebhookService
+	subs   *usecase.SubscriptionService
+	ns     *usecase.NotificationService
+	stream *usecase.EventStream
+
+	scimToken    string
+	githubSecret string
+	gitlabToken  string
+	adminToken   string
 }
 
-func New(prs *usecase.PRService, us *usecase.UserService, ts *usecase.TeamService, ss *usecase.StatService) *Handler {
+func New(prs *usecase.PRService, us *usecase.UserService, ts *usecase.TeamService, ss *usecase.StatService,
+	oss *usecase.OrgSyncService, scs *usecase.ScimService, whs *usecase.WebhookService, subs *usecase.SubscriptionService,
+	ns *usecase.NotificationService, stream *usecase.EventStream,
+	scimToken, githubSecret, gitlabToken, adminToken string) *Handler {
 	return &Handler{
-		prs: prs,
-		us:  us,
-		ts:  ts,
-		ss:  ss,
+		prs:          prs,
+		us:           us,
+		ts:           ts,
+		ss:           ss,
+		oss:          oss,
+		scs:          scs,
+		whs:          whs,
+		subs:         subs,
+		ns:           ns,
+		stream:       stream,
+		scimToken:    scimToken,
+		githubSecret: githubSecret,
+		gitlabToken:  gitlabToken,
+		adminToken:   adminToken,
 	}
 }
 
@@ -139,7 +165,7 @@ func (h *Handler) mergePullRequest(w http.ResponseWriter, r *http.Request) {
 		writeError(w, "INVALID_JSON")
 		return
 	}
-	review, status, wErr := h.prs.MergePullRequest(pullRequest.ID)
+	review, status, wErr := h.prs.MergePullRequest(pullRequest)
 	if wErr != nil {
 		writeJSON(w, status, wErr)
 		return
@@ -173,12 +199,20 @@ func (h *Handler) getGeneralStats(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	stat, err := h.ss.GetGeneralStat()
+	filter, err := parseStatFilter(r)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, err)
+		writeError(w, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, stat)
+
+	stat, status, wErr := h.ss.GetGeneralStat(filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeStat(w, r, status, stat, "stats", func(out io.Writer) error {
+		return usecase.WriteGeneralStatsCSV(out, stat)
+	})
 }
 
 func (h *Handler) getUsersStat(w http.ResponseWriter, r *http.Request) {
@@ -193,13 +227,21 @@ func (h *Handler) getUsersStat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stat, err := h.ss.GetUserStat(userID)
+	filter, err := parseStatFilter(r)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, err)
+		writeError(w, err.Error())
+		return
+	}
+
+	stat, status, wErr := h.ss.GetUserStat(userID, filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, stat)
+	writeStat(w, r, status, stat, "user_stats", func(out io.Writer) error {
+		return usecase.WriteUserStatCSV(out, stat)
+	})
 }
 
 func (h *Handler) deactivateTeam(w http.ResponseWriter, r *http.Request) {
@@ -221,3 +263,449 @@ func (h *Handler) deactivateTeam(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, status, deactiveUsers)
 }
+
+func (h *Handler) renameTeam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rename models.RenameTeam
+	if err := json.NewDecoder(r.Body).Decode(&rename); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	team, status, wErr := h.ts.RenameTeam(rename)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"team": team})
+}
+
+func (h *Handler) deleteTeam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var del models.DeleteTeam
+	if err := json.NewDecoder(r.Body).Decode(&del); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	result, status, wErr := h.ts.DeleteTeam(del)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+func (h *Handler) reactivateTeam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ReactivateTeam
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	result, status, wErr := h.ts.ReactivateTeam(req)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if len(strings.TrimSpace(userID)) == 0 {
+		writeError(w, "invalid userID")
+		return
+	}
+
+	user, status, wErr := h.us.GetUser(userID)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, user)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Username:    query.Get("username"),
+		TeamName:    query.Get("team_name"),
+		AccountType: query.Get("account_type"),
+	}
+	if isActive := query.Get("is_active"); isActive != "" {
+		active, err := strconv.ParseBool(isActive)
+		if err != nil {
+			writeError(w, "invalid is_active")
+			return
+		}
+		filter.IsActive = &active
+	}
+
+	users, status, wErr := h.us.ListUsers(filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"users": users})
+}
+
+func (h *Handler) addUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	created, status, wErr := h.us.CreateUser(user)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, created)
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var info models.UpdateUserInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	updated, status, wErr := h.us.UpdateUserInfo(info)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, updated)
+}
+
+func (h *Handler) syncOrg(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Content-Type"), "csv") {
+		format = "csv"
+	}
+
+	dryRun := false
+	if v := query.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeError(w, "invalid dry_run")
+			return
+		}
+	}
+
+	prune := false
+	if v := query.Get("prune"); v != "" {
+		var err error
+		if prune, err = strconv.ParseBool(v); err != nil {
+			writeError(w, "invalid prune")
+			return
+		}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, "invalid body")
+		return
+	}
+
+	plan, status, wErr := h.oss.Import(data, format, dryRun, prune)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, plan)
+}
+
+func (h *Handler) addIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var identity models.UserIdentity
+	if err := json.NewDecoder(r.Body).Decode(&identity); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	created, status, wErr := h.us.AddIdentity(identity)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, created)
+}
+
+func (h *Handler) deleteIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var identity models.ExternalIdentity
+	if err := json.NewDecoder(r.Body).Decode(&identity); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	status, wErr := h.us.DeleteIdentity(identity)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, identity)
+}
+
+func (h *Handler) getIdentities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if len(strings.TrimSpace(userID)) == 0 {
+		writeError(w, "invalid userID")
+		return
+	}
+
+	identities, status, wErr := h.us.GetIdentities(userID)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"user_id": userID, "identities": identities})
+}
+
+func (h *Handler) lookupIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	identity := models.ExternalIdentity{
+		Provider:   query.Get("provider"),
+		ExternalID: query.Get("external_id"),
+	}
+	if identity.Provider == "" || identity.ExternalID == "" {
+		writeError(w, "provider and external_id are required")
+		return
+	}
+
+	user, status, wErr := h.us.LookupIdentity(identity)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, user)
+}
+
+func (h *Handler) submitReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var review models.ReviewVerdict
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	result, status, wErr := h.prs.SubmitReview(review)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+func (h *Handler) getTurnaroundStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseStatFilter(r)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	stat, status, wErr := h.ss.GetTurnaround(r.URL.Query().Get("group_by"), filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeStat(w, r, status, stat, "turnaround", func(out io.Writer) error {
+		return usecase.WriteTurnaroundCSV(out, stat)
+	})
+}
+
+func (h *Handler) getTeamStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamNames := r.URL.Query()["team_name"]
+	if len(teamNames) == 0 {
+		writeError(w, "invalid team name")
+		return
+	}
+
+	filter, err := parseStatFilter(r)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	stats, status, wErr := h.ss.GetTeamStats(teamNames, filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+
+	var data interface{} = map[string]interface{}{"teams": stats}
+	if len(stats) == 1 {
+		data = stats[0]
+	}
+	writeStat(w, r, status, data, "team_stats", func(out io.Writer) error {
+		return usecase.WriteTeamStatsCSV(out, stats)
+	})
+}
+
+func (h *Handler) getFairness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseStatFilter(r)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	report, status, wErr := h.ss.GetFairness(r.URL.Query()["team_name"], filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeStat(w, r, status, report, "fairness", func(out io.Writer) error {
+		return usecase.WriteFairnessCSV(out, report)
+	})
+}
+
+func (h *Handler) getReviewMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseStatFilter(r)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+	filter.TeamName = r.URL.Query().Get("team_name")
+
+	matrix, status, wErr := h.ss.GetReviewMatrix(filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, status, matrix)
+	case "csv":
+		writeCSVFile(w, "review_matrix", func(out io.Writer) error {
+			return usecase.WriteMatrixCSV(out, matrix)
+		})
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		_ = usecase.WriteMatrixDOT(w, matrix)
+	default:
+		writeError(w, "invalid format")
+	}
+}
+
+func (h *Handler) getReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseStatFilter(r)
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "md", "html", "json":
+	default:
+		writeError(w, "invalid format")
+		return
+	}
+
+	report, status, wErr := h.ss.GetReport(r.URL.Query()["team_name"], filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+
+	switch format {
+	case "", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_ = usecase.WriteReportMarkdown(w, report)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = usecase.WriteReportHTML(w, report)
+	case "json":
+		writeJSON(w, status, report)
+	}
+}
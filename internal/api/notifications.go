@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"net/http"
+	"strings"
+)
+
+func (h *Handler) listChatWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	hooks, status, wErr := h.ns.ListChatWebhooks()
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"webhooks": hooks})
+}
+
+func (h *Handler) setChatWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var hook models.ChatWebhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	saved, status, wErr := h.ns.SetChatWebhook(hook)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"webhook": saved})
+}
+
+func (h *Handler) deleteChatWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var hook models.ChatWebhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	status, wErr := h.ns.DeleteChatWebhook(hook)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, hook)
+}
+
+func (h *Handler) getPreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if len(strings.TrimSpace(userID)) == 0 {
+		writeError(w, "invalid userID")
+		return
+	}
+
+	prefs, status, wErr := h.ns.GetPreferences(userID)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, prefs)
+}
+
+func (h *Handler) setPreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var prefs models.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	saved, status, wErr := h.ns.SetPreferences(prefs)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, saved)
+}
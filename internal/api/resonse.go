@@ -2,7 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"io"
 	"net/http"
+	"time"
 )
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -14,3 +18,42 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 func writeError(w http.ResponseWriter, msg string) {
 	writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
 }
+
+// writeStat format=json (по умолчанию) или csv
+func writeStat(w http.ResponseWriter, r *http.Request, status int, data interface{}, name string, toCSV func(io.Writer) error) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, status, data)
+	case "csv":
+		writeCSVFile(w, name, toCSV)
+	default:
+		writeError(w, "invalid format")
+	}
+}
+
+func writeCSVFile(w http.ResponseWriter, name string, toCSV func(io.Writer) error) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	_ = toCSV(w)
+}
+
+// parseStatFilter from/to в формате RFC3339 или 2006-01-02, bucket = day|week|month
+func parseStatFilter(r *http.Request) (models.StatFilter, error) {
+	query := r.URL.Query()
+	filter := models.StatFilter{Bucket: query.Get("bucket")}
+
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, value); err != nil {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+		}
+		*dst = &t
+	}
+	return filter, nil
+}
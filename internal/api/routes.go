@@ -7,22 +7,72 @@ func (h *Handler) RegisterRouters(mux *http.ServeMux) {
 		mux.HandleFunc("/team/add", h.addTeam) //done
 		mux.HandleFunc("/team/get", h.getTeam) //done
 		mux.HandleFunc("/team/deactive", h.deactivateTeam)
+		mux.HandleFunc("/team/reactivate", h.reactivateTeam)
+		mux.HandleFunc("/team/rename", h.renameTeam)
+		mux.HandleFunc("/org/sync", h.syncOrg)
+		mux.HandleFunc("/team/delete", h.deleteTeam)
+		mux.HandleFunc("/team/sla", h.getReviewSLA)
+		mux.HandleFunc("/team/sla/set", h.setReviewSLA)
+		mux.HandleFunc("/team/sla/delete", h.deleteReviewSLA)
 	}
 
 	{
 		mux.HandleFunc("/users/setIsActive", h.setUser)
 		mux.HandleFunc("/users/getReview", h.getUserReviews) //todo all time 200 ???
+		mux.HandleFunc("/users/get", h.getUser)
+		mux.HandleFunc("/users/list", h.listUsers)
+		mux.HandleFunc("/users/add", h.addUser)
+		mux.HandleFunc("/users/update", h.updateUser)
+		mux.HandleFunc("/users/identities", h.getIdentities)
+		mux.HandleFunc("/users/identities/add", h.addIdentity)
+		mux.HandleFunc("/users/identities/delete", h.deleteIdentity)
+		mux.HandleFunc("/users/identities/lookup", h.lookupIdentity)
+		mux.HandleFunc("/users/preferences", h.getPreferences)
+		mux.HandleFunc("/users/preferences/set", h.setPreferences)
 	}
 
 	{
 		mux.HandleFunc("/pullRequests/create", h.createPullRequest)
 		mux.HandleFunc("/pullRequests/merge", h.mergePullRequest)
 		mux.HandleFunc("/pullRequests/reassign", h.reassignPullRequest)
+		mux.HandleFunc("/pullRequests/review", h.submitReview)
 	}
 
 	{
 		mux.HandleFunc("/stats", h.getGeneralStats)
 		mux.HandleFunc("/stats/user", h.getUsersStat)
+		mux.HandleFunc("/stats/turnaround", h.getTurnaroundStats)
+		mux.HandleFunc("/stats/team", h.getTeamStat)
+		mux.HandleFunc("/stats/fairness", h.getFairness)
+		mux.HandleFunc("/stats/matrix", h.getReviewMatrix)
+		mux.HandleFunc("/stats/report", h.getReport)
+	}
+
+	{
+		mux.HandleFunc("/scim/v2/Users", h.scimUsers)
+		mux.HandleFunc("/scim/v2/Users/", h.scimUser)
+		mux.HandleFunc("/scim/v2/Groups", h.scimGroups)
+		mux.HandleFunc("/scim/v2/Groups/", h.scimGroup)
+	}
+
+	{
+		mux.HandleFunc("/webhooks/github", h.githubWebhook)
+		mux.HandleFunc("/webhooks/gitlab", h.gitlabWebhook)
+		mux.HandleFunc("/webhooks/subscriptions", h.listSubscriptions)
+		mux.HandleFunc("/webhooks/subscriptions/add", h.addSubscription)
+		mux.HandleFunc("/webhooks/subscriptions/delete", h.deleteSubscription)
+		mux.HandleFunc("/webhooks/deliveries", h.listDeliveries)
+		mux.HandleFunc("/webhooks/deliveries/replay", h.replayDeliveries)
+	}
+
+	{
+		mux.HandleFunc("/notifications/chat", h.listChatWebhooks)
+		mux.HandleFunc("/notifications/chat/set", h.setChatWebhook)
+		mux.HandleFunc("/notifications/chat/delete", h.deleteChatWebhook)
+	}
+
+	{
+		mux.HandleFunc("/events/stream", h.streamEvents)
 	}
 
 }
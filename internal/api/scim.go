@@ -0,0 +1,247 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (h *Handler) scimUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.scimAuthorized(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		startIndex, count, ok := scimPagination(w, r)
+		if !ok {
+			return
+		}
+		list, status, wErr := h.scs.ListUsers(r.URL.Query().Get("filter"), startIndex, count)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, list)
+	case http.MethodPost:
+		var user models.ScimUser
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidSyntax", Message: "invalid json"})
+			return
+		}
+		created, status, wErr := h.scs.CreateUser(user)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, created)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) scimUser(w http.ResponseWriter, r *http.Request) {
+	if !h.scimAuthorized(w, r) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/scim/v2/Users/")
+	if id == "" || strings.Contains(id, "/") {
+		writeScimError(w, http.StatusNotFound, &usecase.Error{Code: "NOT_FOUND", Message: "resource not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user, status, wErr := h.scs.GetUser(id)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, user)
+	case http.MethodPut:
+		var user models.ScimUser
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidSyntax", Message: "invalid json"})
+			return
+		}
+		updated, status, wErr := h.scs.ReplaceUser(id, user)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, updated)
+	case http.MethodPatch:
+		var patch models.ScimPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidSyntax", Message: "invalid json"})
+			return
+		}
+		updated, status, wErr := h.scs.PatchUser(id, patch)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, updated)
+	case http.MethodDelete:
+		status, wErr := h.scs.DeleteUser(id)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		w.WriteHeader(status)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) scimGroups(w http.ResponseWriter, r *http.Request) {
+	if !h.scimAuthorized(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		startIndex, count, ok := scimPagination(w, r)
+		if !ok {
+			return
+		}
+		list, status, wErr := h.scs.ListGroups(r.URL.Query().Get("filter"), startIndex, count)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, list)
+	case http.MethodPost:
+		var group models.ScimGroup
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidSyntax", Message: "invalid json"})
+			return
+		}
+		created, status, wErr := h.scs.CreateGroup(group)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, created)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) scimGroup(w http.ResponseWriter, r *http.Request) {
+	if !h.scimAuthorized(w, r) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/scim/v2/Groups/")
+	if id == "" || strings.Contains(id, "/") {
+		writeScimError(w, http.StatusNotFound, &usecase.Error{Code: "NOT_FOUND", Message: "resource not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		group, status, wErr := h.scs.GetGroup(id)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, group)
+	case http.MethodPatch:
+		var patch models.ScimPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidSyntax", Message: "invalid json"})
+			return
+		}
+		group, status, wErr := h.scs.PatchGroup(id, patch)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		writeScim(w, status, group)
+	case http.MethodDelete:
+		status, wErr := h.scs.DeleteGroup(id)
+		if wErr != nil {
+			writeScimError(w, status, wErr)
+			return
+		}
+		w.WriteHeader(status)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// scimAuthorized если SCIM_TOKEN не задан, эндпоинты закрыты (503), как вебхуки без секрета
+func (h *Handler) scimAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if h.scimToken == "" {
+		writeScimError(w, http.StatusServiceUnavailable, &usecase.Error{Code: "SCIM_NOT_CONFIGURED", Message: "SCIM_TOKEN is not set"})
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.scimToken)) == 1 {
+		return true
+	}
+	writeScimError(w, http.StatusUnauthorized, &usecase.Error{Code: "UNAUTHORIZED", Message: "invalid bearer token"})
+	return false
+}
+
+func scimPagination(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	startIndex, count := 1, 100
+	query := r.URL.Query()
+	if v := query.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidValue", Message: "invalid startIndex"})
+			return 0, 0, false
+		}
+		startIndex = max(n, 1)
+	}
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeScimError(w, http.StatusBadRequest, &usecase.Error{Code: "invalidValue", Message: "invalid count"})
+			return 0, 0, false
+		}
+		count = max(n, 0)
+	}
+	return startIndex, count, true
+}
+
+func writeScim(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeScimError(w http.ResponseWriter, status int, wErr *usecase.Error) {
+	scimErr := models.ScimError{
+		Schemas: []string{models.ScimErrorSchema},
+		Detail:  wErr.Message,
+	}
+
+	switch wErr.Code {
+	case "USER_EXISTS", "TEAM_EXISTS":
+		status = http.StatusConflict
+		scimErr.ScimType = "uniqueness"
+	case "NOT_FOUND", "TEAM_NOT_FOUND", "UNAUTHORIZED", "SCIM_NOT_CONFIGURED", "INTERNAL_SERVER_ERROR":
+	default:
+		scimErr.ScimType = wErr.Code
+		if strings.ToUpper(wErr.Code) == wErr.Code {
+			scimErr.ScimType = "invalidValue"
+		}
+		if status == http.StatusUnprocessableEntity {
+			status = http.StatusBadRequest
+		}
+	}
+	if scimErr.Detail == "" {
+		scimErr.Detail = wErr.Code
+	}
+
+	scimErr.Status = strconv.Itoa(status)
+	writeScim(w, status, scimErr)
+}
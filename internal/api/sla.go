@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"net/http"
+)
+
+func (h *Handler) getReviewSLA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	teamName := r.URL.Query().Get("team_name")
+	if teamName == "" {
+		writeError(w, "invalid team name")
+		return
+	}
+
+	sla, status, wErr := h.ts.GetReviewSLA(teamName)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, sla)
+}
+
+func (h *Handler) setReviewSLA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sla models.ReviewSLA
+	if err := json.NewDecoder(r.Body).Decode(&sla); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	saved, status, wErr := h.ts.SetReviewSLA(sla)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, saved)
+}
+
+func (h *Handler) deleteReviewSLA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var team models.Team
+	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	status, wErr := h.ts.DeleteReviewSLA(team.Name)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, team)
+}
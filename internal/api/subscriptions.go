@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// adminAuthorized подписки задают адреса, на которые сервис сам шлет запросы, поэтому доступны
+// только с Authorization: Bearer ADMIN_TOKEN. Без ADMIN_TOKEN эндпоинты закрыты
+func (h *Handler) adminAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if h.adminToken == "" {
+		writeJSON(w, http.StatusServiceUnavailable, &usecase.Error{Code: "ADMIN_NOT_CONFIGURED", Message: "ADMIN_TOKEN is not set"})
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1 {
+		return true
+	}
+	writeJSON(w, http.StatusUnauthorized, &usecase.Error{Code: "UNAUTHORIZED", Message: "invalid bearer token"})
+	return false
+}
+
+func (h *Handler) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	subs, status, wErr := h.subs.ListSubscriptions()
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"subscriptions": subs})
+}
+
+func (h *Handler) addSubscription(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sub models.WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	created, status, wErr := h.subs.CreateSubscription(sub)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"subscription": created})
+}
+
+func (h *Handler) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	status, wErr := h.subs.DeleteSubscription(req.ID)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, req)
+}
+
+func (h *Handler) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.DeliveryFilter{Status: query.Get("status")}
+	if v := query.Get("subscription_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, "invalid subscription_id")
+			return
+		}
+		filter.SubscriptionID = id
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, "invalid limit")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	deliveries, status, wErr := h.subs.ListDeliveries(filter)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"deliveries": deliveries})
+}
+
+func (h *Handler) replayDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !h.adminAuthorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var replay models.ReplayDeliveries
+	if err := json.NewDecoder(r.Body).Decode(&replay); err != nil {
+		writeError(w, "INVALID_JSON")
+		return
+	}
+
+	count, status, wErr := h.subs.ReplayDeliveries(replay)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, map[string]interface{}{"replayed": count})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// webhookMaxBody GitHub ограничивает payload 25 МБ, для GitLab берем тот же предел
+const webhookMaxBody = 25 << 20
+
+func (h *Handler) githubWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.githubSecret == "" {
+		writeJSON(w, http.StatusServiceUnavailable, &usecase.Error{Code: "WEBHOOK_NOT_CONFIGURED", Message: "GITHUB_WEBHOOK_SECRET is not set"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		writeError(w, "invalid body")
+		return
+	}
+	if !validGitHubSignature(h.githubSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		writeJSON(w, http.StatusUnauthorized, &usecase.Error{Code: "INVALID_SIGNATURE", Message: "invalid X-Hub-Signature-256"})
+		return
+	}
+
+	delivery, status, wErr := h.whs.HandleGitHub(r.Header.Get("X-GitHub-Event"), r.Header.Get("X-GitHub-Delivery"), body)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, delivery)
+}
+
+func (h *Handler) gitlabWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.gitlabToken == "" {
+		writeJSON(w, http.StatusServiceUnavailable, &usecase.Error{Code: "WEBHOOK_NOT_CONFIGURED", Message: "GITLAB_WEBHOOK_TOKEN is not set"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(h.gitlabToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, &usecase.Error{Code: "INVALID_TOKEN", Message: "invalid X-Gitlab-Token"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		writeError(w, "invalid body")
+		return
+	}
+
+	// Idempotency-Key одинаковый у повторных доставок одного события
+	deliveryID := r.Header.Get("Idempotency-Key")
+	if deliveryID == "" {
+		deliveryID = r.Header.Get("X-Gitlab-Event-UUID")
+	}
+
+	delivery, status, wErr := h.whs.HandleGitLab(r.Header.Get("X-Gitlab-Event"), deliveryID, body)
+	if wErr != nil {
+		writeJSON(w, status, wErr)
+		return
+	}
+	writeJSON(w, status, delivery)
+}
+
+// validGitHubSignature header = "sha256=" + hex(HMAC-SHA256(secret, body))
+func validGitHubSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
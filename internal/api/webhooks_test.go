@@ -0,0 +1,466 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/internal/usecase"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+const testSecret = "s3cr3t"
+
+// webhookRepo репозиторий в памяти для пути вебхука. Transaction откатывает изменения при ошибке
+type webhookRepo struct {
+	repository.Repository
+
+	users      map[string]models.User
+	identities map[string]string
+	prs        map[string]models.PullRequest
+	reviewers  map[string][]string
+	deliveries map[string]models.WebhookDelivery
+	events     []models.Event
+}
+
+func newWebhookRepo() *webhookRepo {
+	return &webhookRepo{
+		users: map[string]models.User{
+			"u1": {ID: "u1", TeamName: "backend", IsActive: true, AccountType: "HUMAN"},
+			"u2": {ID: "u2", TeamName: "backend", IsActive: true, AccountType: "HUMAN"},
+			"u3": {ID: "u3", TeamName: "backend", IsActive: true, AccountType: "HUMAN"},
+		},
+		identities: map[string]string{"github:octocat": "u1"},
+		prs:        make(map[string]models.PullRequest),
+		reviewers:  make(map[string][]string),
+		deliveries: make(map[string]models.WebhookDelivery),
+	}
+}
+
+func (r *webhookRepo) Transaction(fn func(tx repository.Repository) error) error {
+	prs, reviewers, deliveries, events := maps.Clone(r.prs), maps.Clone(r.reviewers), maps.Clone(r.deliveries), len(r.events)
+	if err := fn(r); err != nil {
+		r.prs, r.reviewers, r.deliveries, r.events = prs, reviewers, deliveries, r.events[:events]
+		return err
+	}
+	return nil
+}
+
+func (r *webhookRepo) ClaimWebhookDelivery(d *models.WebhookDelivery) (bool, error) {
+	key := d.Provider + ":" + d.DeliveryID
+	if _, ok := r.deliveries[key]; ok {
+		return false, nil
+	}
+	r.deliveries[key] = *d
+	return true, nil
+}
+
+func (r *webhookRepo) GetWebhookDelivery(provider, deliveryID string) (*models.WebhookDelivery, bool, error) {
+	d, ok := r.deliveries[provider+":"+deliveryID]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &d, false, nil
+}
+
+func (r *webhookRepo) UpdateWebhookDelivery(d *models.WebhookDelivery) error {
+	r.deliveries[d.Provider+":"+d.DeliveryID] = *d
+	return nil
+}
+
+func (r *webhookRepo) GetUserByID(id string) (*models.User, bool, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &u, false, nil
+}
+
+func (r *webhookRepo) GetUserByIdentity(provider, externalID string) (*models.User, bool, error) {
+	id, ok := r.identities[provider+":"+externalID]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return r.GetUserByID(id)
+}
+
+func (r *webhookRepo) GetUsersIDByTeamName(teamName, authorID string) ([]string, bool, error) {
+	var ids []string
+	for _, id := range []string{"u1", "u2", "u3"} {
+		if u := r.users[id]; u.TeamName == teamName && id != authorID && len(ids) < 2 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, len(ids) == 0, nil
+}
+
+func (r *webhookRepo) GetPullRequestByID(id string) (*models.PullRequest, bool, error) {
+	pr, ok := r.prs[id]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &pr, false, nil
+}
+
+func (r *webhookRepo) CreatePullRequest(pr *models.PullRequest, reviewers []models.PrReviewer) error {
+	if _, ok := r.prs[pr.ID]; ok {
+		return os.ErrExist
+	}
+	r.prs[pr.ID] = *pr
+	for _, rv := range reviewers {
+		r.reviewers[pr.ID] = append(r.reviewers[pr.ID], rv.ReviewerID)
+	}
+	return nil
+}
+
+func (r *webhookRepo) MergePullRequest(pr *models.PullRequest) (bool, error) {
+	existing, ok := r.prs[pr.ID]
+	if !ok || existing.Status != "OPEN" {
+		return true, nil
+	}
+	existing.Status, existing.MergedAt = pr.Status, pr.MergedAt
+	r.prs[pr.ID] = existing
+	*pr = existing
+	return false, nil
+}
+
+func (r *webhookRepo) GetUsersIDByReviewID(id string) ([]string, error) {
+	return r.reviewers[id], nil
+}
+
+func (r *webhookRepo) GetPullRequestTeam(prID string) (string, error) {
+	return r.users[r.prs[prID].AuthorID].TeamName, nil
+}
+
+func (r *webhookRepo) AddOutboxEvents(events []models.Event) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) NoCandidate(string)              {}
+func (nopMetrics) SetOpenPRs(int64)                {}
+func (nopMetrics) SetOpenReviews(map[string]int64) {}
+
+func newWebhookHandler(repo repository.Repository, secret string) *Handler {
+	l := logger.New()
+	prs := usecase.NewPRService(repo, l, nopMetrics{})
+	return &Handler{whs: usecase.NewWebhookService(repo, prs, l), githubSecret: secret}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "github", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type githubDelivery struct {
+	event, id, fixture string
+	signature          func(body []byte) string
+}
+
+func (d githubDelivery) send(t *testing.T, h *Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	body := []byte("{}")
+	if d.fixture != "" {
+		body = readFixture(t, d.fixture)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", d.event)
+	req.Header.Set("X-GitHub-Delivery", d.id)
+	signature := sign(testSecret, body)
+	if d.signature != nil {
+		signature = d.signature(body)
+	}
+	if signature != "" {
+		req.Header.Set("X-Hub-Signature-256", signature)
+	}
+	rec := httptest.NewRecorder()
+	h.githubWebhook(rec, req)
+	return rec
+}
+
+func TestGitHubWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		signature func(body []byte) string
+		status    int
+		code      string
+	}{
+		{name: "secret not set", secret: "", status: http.StatusServiceUnavailable, code: "WEBHOOK_NOT_CONFIGURED"},
+		{name: "no signature", secret: testSecret, signature: func([]byte) string { return "" }, status: http.StatusUnauthorized, code: "INVALID_SIGNATURE"},
+		{name: "sha1 prefix", secret: testSecret, signature: func(b []byte) string { return "sha1=" + sign(testSecret, b)[7:] }, status: http.StatusUnauthorized, code: "INVALID_SIGNATURE"},
+		{name: "not hex", secret: testSecret, signature: func([]byte) string { return "sha256=zz" }, status: http.StatusUnauthorized, code: "INVALID_SIGNATURE"},
+		{name: "other secret", secret: testSecret, signature: func(b []byte) string { return sign("other", b) }, status: http.StatusUnauthorized, code: "INVALID_SIGNATURE"},
+		{name: "tampered body", secret: testSecret, signature: func(b []byte) string { return sign(testSecret, append(b, ' ')) }, status: http.StatusUnauthorized, code: "INVALID_SIGNATURE"},
+		{name: "valid", secret: testSecret, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newWebhookRepo()
+			rec := githubDelivery{event: "pull_request", id: "d-1", fixture: "opened.json", signature: tt.signature}.
+				send(t, newWebhookHandler(repo, tt.secret))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				var wErr usecase.Error
+				if err := json.Unmarshal(rec.Body.Bytes(), &wErr); err != nil || wErr.Code != tt.code {
+					t.Fatalf("error = %s, want %s", rec.Body, tt.code)
+				}
+				if len(repo.deliveries) != 0 || len(repo.prs) != 0 {
+					t.Fatalf("rejected delivery changed state: %d deliveries, %d prs", len(repo.deliveries), len(repo.prs))
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubWebhookActions(t *testing.T) {
+	const prID = "acme/api#42"
+	created := []string{models.EventPRCreated, models.EventReviewerAssigned, models.EventReviewerAssigned}
+	openPR := func(status string) func(r *webhookRepo) {
+		return func(r *webhookRepo) {
+			r.prs[prID] = models.PullRequest{ID: prID, AuthorID: "u1", Status: status}
+			r.reviewers[prID] = []string{"u2", "u3"}
+		}
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(r *webhookRepo)
+		deliveries []githubDelivery
+		status     int
+		result     string
+		reason     string
+		duplicate  bool
+		prStatus   string
+		events     []string
+	}{
+		{
+			name:       "opened",
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "opened.json"}},
+			status:     http.StatusOK, result: "PROCESSED", prStatus: "OPEN",
+			events: created,
+		},
+		{
+			name:       "opened as draft",
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "opened_draft.json"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "draft",
+		},
+		{
+			name: "draft then ready_for_review",
+			deliveries: []githubDelivery{
+				{event: "pull_request", id: "d-1", fixture: "opened_draft.json"},
+				{event: "pull_request", id: "d-2", fixture: "ready_for_review.json"},
+			},
+			status: http.StatusOK, result: "PROCESSED", prStatus: "OPEN",
+			events: created,
+		},
+		{
+			name:       "opened for existing PR",
+			setup:      openPR("OPEN"),
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "opened.json"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "pull request already OPEN", prStatus: "OPEN",
+		},
+		{
+			name:       "closed and merged",
+			setup:      openPR("OPEN"),
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "closed_merged.json"}},
+			status:     http.StatusOK, result: "PROCESSED", prStatus: "MERGED",
+			events: []string{models.EventPRMerged},
+		},
+		{
+			name:       "closed and merged twice with different deliveries",
+			setup:      openPR("MERGED"),
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "closed_merged.json"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "pull request already MERGED", prStatus: "MERGED",
+		},
+		{
+			name:       "closed and merged unknown PR",
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "closed_merged.json"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "unknown pull request",
+		},
+		{
+			name:       "closed without merge",
+			setup:      openPR("OPEN"),
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "closed_unmerged.json"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "closed without merge", prStatus: "OPEN",
+		},
+		{
+			name: "redelivery of opened",
+			deliveries: []githubDelivery{
+				{event: "pull_request", id: "d-1", fixture: "opened.json"},
+				{event: "pull_request", id: "d-1", fixture: "opened.json"},
+			},
+			status: http.StatusOK, result: "PROCESSED", duplicate: true, prStatus: "OPEN",
+			events: created,
+		},
+		{
+			name:  "redelivery of merged",
+			setup: openPR("OPEN"),
+			deliveries: []githubDelivery{
+				{event: "pull_request", id: "d-1", fixture: "closed_merged.json"},
+				{event: "pull_request", id: "d-1", fixture: "closed_merged.json"},
+			},
+			status: http.StatusOK, result: "PROCESSED", duplicate: true, prStatus: "MERGED",
+			events: []string{models.EventPRMerged},
+		},
+		{
+			name:       "ping",
+			deliveries: []githubDelivery{{event: "ping", id: "d-1"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "ping",
+		},
+		{
+			name:       "unsupported event",
+			deliveries: []githubDelivery{{event: "issues", id: "d-1"}},
+			status:     http.StatusOK, result: "IGNORED", reason: "unsupported event",
+		},
+		{
+			name:       "no delivery id",
+			deliveries: []githubDelivery{{event: "pull_request", fixture: "opened.json"}},
+			status:     http.StatusBadRequest, result: "INVALID_DELIVERY",
+		},
+		{
+			name:       "unknown author is not recorded",
+			setup:      func(r *webhookRepo) { delete(r.identities, "github:octocat") },
+			deliveries: []githubDelivery{{event: "pull_request", id: "d-1", fixture: "opened.json"}},
+			status:     http.StatusNotFound, result: "NOT_FOUND",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newWebhookRepo()
+			if tt.setup != nil {
+				tt.setup(repo)
+			}
+			h := newWebhookHandler(repo, testSecret)
+
+			var rec *httptest.ResponseRecorder
+			for _, d := range tt.deliveries {
+				rec = d.send(t, h)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			if tt.status != http.StatusOK {
+				var wErr usecase.Error
+				if err := json.Unmarshal(rec.Body.Bytes(), &wErr); err != nil || wErr.Code != tt.result {
+					t.Fatalf("error = %s, want %s", rec.Body, tt.result)
+				}
+				if len(repo.deliveries) != 0 {
+					t.Fatalf("failed delivery recorded: %+v", repo.deliveries)
+				}
+			} else {
+				var delivery models.WebhookDelivery
+				if err := json.Unmarshal(rec.Body.Bytes(), &delivery); err != nil {
+					t.Fatal(err)
+				}
+				if delivery.Status != tt.result || delivery.Reason != tt.reason || delivery.Duplicate != tt.duplicate {
+					t.Fatalf("delivery = %+v, want status %s reason %q duplicate %v", delivery, tt.result, tt.reason, tt.duplicate)
+				}
+			}
+
+			pr, ok := repo.prs[prID]
+			if tt.prStatus == "" && ok || tt.prStatus != "" && pr.Status != tt.prStatus {
+				t.Fatalf("pr = %+v (exists %v), want status %q", pr, ok, tt.prStatus)
+			}
+			if tt.prStatus == "OPEN" && tt.setup == nil && (pr.AuthorID != "u1" || pr.Name != "Add rate limiter" || len(repo.reviewers[prID]) != 2) {
+				t.Fatalf("pr = %+v reviewers %v", pr, repo.reviewers[prID])
+			}
+
+			var events []string
+			for _, e := range repo.events {
+				events = append(events, e.Type)
+			}
+			if !slices.Equal(events, tt.events) {
+				t.Fatalf("events = %v, want %v", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestGitLabWebhookAuthor(t *testing.T) {
+	const prID = "acme/api!7"
+	payload := []byte(`{"object_kind":"merge_request","user":{"username":"octocat"},
+"project":{"path_with_namespace":"acme/api"},
+"object_attributes":{"iid":7,"author_id":1234,"title":"Add cache","state":"opened","action":"open"}}`)
+	send := func(h *Handler, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/gitlab", bytes.NewReader(payload))
+		req.Header.Set("X-Gitlab-Token", testSecret)
+		req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		h.gitlabWebhook(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		identities map[string]string
+		status     int
+		author     string
+	}{
+		{name: "author by gitlab_id", identities: map[string]string{"gitlab_id:1234": "u1"}, status: http.StatusOK, author: "u1"},
+		{name: "username that looks like id", identities: map[string]string{"gitlab:1234": "u2"}, status: http.StatusNotFound},
+		{name: "unknown author", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newWebhookRepo()
+			maps.Copy(repo.identities, tt.identities)
+			h := newWebhookHandler(repo, "")
+			h.gitlabToken = testSecret
+
+			rec := send(h, "k-1")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if len(repo.deliveries) != 0 || len(repo.prs) != 0 {
+					t.Fatalf("rejected delivery changed state: %+v %+v", repo.deliveries, repo.prs)
+				}
+				// после привязки та же доставка обрабатывается, а не отдается как дубликат
+				repo.identities["gitlab_id:1234"] = "u3"
+				if rec = send(h, "k-1"); rec.Code != http.StatusOK {
+					t.Fatalf("redelivery status = %d: %s", rec.Code, rec.Body)
+				}
+				tt.author = "u3"
+			}
+
+			var delivery models.WebhookDelivery
+			if err := json.Unmarshal(rec.Body.Bytes(), &delivery); err != nil {
+				t.Fatal(err)
+			}
+			if delivery.Status != "PROCESSED" || delivery.Duplicate {
+				t.Fatalf("delivery = %+v", delivery)
+			}
+			if pr := repo.prs[prID]; pr.AuthorID != tt.author {
+				t.Fatalf("author = %q, want %q", pr.AuthorID, tt.author)
+			}
+		})
+	}
+}
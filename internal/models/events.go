@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Типы доменных событий
+const (
+	EventPRCreated        = "pr.created"
+	EventReviewerAssigned = "pr.reviewer_assigned"
+	EventReviewerReplaced = "pr.reviewer_replaced"
+	EventReviewerRemoved  = "pr.reviewer_removed"
+	EventPRMerged         = "pr.merged"
+	EventTeamDeactivated  = "team.deactivated"
+	EventReviewReminder   = "pr.review_reminder"
+	EventReviewEscalated  = "pr.review_escalated"
+)
+
+var EventTypes = []string{
+	EventPRCreated,
+	EventReviewerAssigned,
+	EventReviewerReplaced,
+	EventReviewerRemoved,
+	EventPRMerged,
+	EventTeamDeactivated,
+	EventReviewReminder,
+	EventReviewEscalated,
+}
+
+// Event TeamName - команда автора PR (для team.deactivated - сама команда),
+// UserIDs - пользователи, которых касается событие. Data зависит от Type:
+// pr.created, pr.merged - Review; pr.reviewer_assigned - ReviewerAssignment;
+// pr.reviewer_replaced, pr.reviewer_removed - Reassignment; team.deactivated - TeamDeactivated;
+// pr.review_reminder, pr.review_escalated - SLAAction
+type Event struct {
+	ID            int64           `json:"id,omitempty"`
+	Type          string          `json:"type"`
+	PullRequestID string          `json:"pull_request_id,omitempty"`
+	TeamName      string          `json:"team_name,omitempty"`
+	UserIDs       []string        `json:"user_ids,omitempty" gorm:"serializer:json"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Data          json.RawMessage `json:"data" gorm:"serializer:json"`
+}
+
+// OutboxEvent событие в outbox. OrderingKey - PR или "team:<name>", DeliveredAt nil - еще не отправлено
+type OutboxEvent struct {
+	Event
+	OrderingKey   string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	DeliveredAt   *time.Time
+}
+
+type ReviewerAssignment struct {
+	PullRequestID string `json:"pull_request_id"`
+	ReviewerID    string `json:"reviewer_id"`
+}
+
+// SLAAction напоминание ревьюверу или эскалация по SLA команды. Action для эскалации: lead - LeadID уведомлен,
+// reassign - ревьювер заменен на NewReviewerID
+type SLAAction struct {
+	PullRequestID string    `json:"pull_request_id"`
+	ReviewerID    string    `json:"reviewer_id"`
+	AssignedAt    time.Time `json:"assigned_at"`
+	Stage         string    `json:"-"`
+	Action        string    `json:"action,omitempty" gorm:"-"`
+	LeadID        string    `json:"lead_id,omitempty" gorm:"-"`
+	NewReviewerID string    `json:"new_reviewer_id,omitempty" gorm:"-"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+type TeamDeactivated struct {
+	TeamName string `json:"team_name"`
+	Users    []User `json:"users"`
+}
+
+// WebhookSubscription Events пустой - подписка на все события. Secret наружу не отдается
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events" gorm:"serializer:json"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SubscriptionDelivery Status: PENDING - ждет отправки (в т.ч. повторной), SUCCEEDED, FAILED - попытки исчерпаны
+type SubscriptionDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload" gorm:"serializer:json"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type DeliveryFilter struct {
+	SubscriptionID int64
+	Status         string
+	Limit          int
+}
+
+// ReplayDeliveries либо одна доставка, либо все FAILED доставки подписки
+type ReplayDeliveries struct {
+	DeliveryID     int64 `json:"delivery_id"`
+	SubscriptionID int64 `json:"subscription_id"`
+}
@@ -0,0 +1,26 @@
+package models
+
+// GitHubPullRequestEvent нужные поля события pull_request
+type GitHubPullRequestEvent struct {
+	Action      string            `json:"action"`
+	Number      int               `json:"number"`
+	PullRequest GitHubPullRequest `json:"pull_request"`
+	Repository  GitHubRepository  `json:"repository"`
+}
+
+type GitHubPullRequest struct {
+	Number int        `json:"number"`
+	Title  string     `json:"title"`
+	State  string     `json:"state"`
+	Draft  bool       `json:"draft"`
+	Merged bool       `json:"merged"`
+	User   GitHubUser `json:"user"`
+}
+
+type GitHubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type GitHubUser struct {
+	Login string `json:"login"`
+}
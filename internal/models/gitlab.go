@@ -0,0 +1,36 @@
+package models
+
+import "encoding/json"
+
+// GitLabMergeRequestEvent нужные поля Merge Request Hook
+type GitLabMergeRequestEvent struct {
+	ObjectKind       string             `json:"object_kind"`
+	User             GitLabUser         `json:"user"`
+	Project          GitLabProject      `json:"project"`
+	ObjectAttributes GitLabMergeRequest `json:"object_attributes"`
+	Reviewers        []GitLabUser       `json:"reviewers"`
+	Changes          GitLabChanges      `json:"changes"`
+}
+
+type GitLabMergeRequest struct {
+	IID            int    `json:"iid"`
+	AuthorID       int    `json:"author_id"`
+	Title          string `json:"title"`
+	State          string `json:"state"`
+	Action         string `json:"action"`
+	Draft          bool   `json:"draft"`
+	WorkInProgress bool   `json:"work_in_progress"`
+}
+
+type GitLabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type GitLabUser struct {
+	Username string `json:"username"`
+}
+
+// GitLabChanges нас интересует только факт изменения ревьюверов
+type GitLabChanges struct {
+	Reviewers json.RawMessage `json:"reviewers,omitempty"`
+}
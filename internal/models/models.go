@@ -3,12 +3,30 @@ package models
 import "time"
 
 type User struct {
-	ID        string    `json:"user_id" gorm:"primaryKey;default:gen_random_uuid()"`
-	Username  string    `json:"username"`
-	IsActive  bool      `json:"is_active"`
-	TeamName  string    `json:"team_name"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID          string    `json:"user_id" gorm:"primaryKey;default:gen_random_uuid()"`
+	Username    string    `json:"username"`
+	IsActive    bool      `json:"is_active"`
+	TeamName    string    `json:"team_name"`
+	AccountType string    `json:"account_type"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
+
+type UserFilter struct {
+	Username      string
+	ExactUsername bool
+	TeamName      string
+	AccountType   string
+	IsActive      *bool
+	Offset        int
+	Limit         int
+}
+
+type UpdateUserInfo struct {
+	ID          string `json:"user_id"`
+	Username    string `json:"username"`
+	TeamName    string `json:"team_name"`
+	AccountType string `json:"account_type"`
 }
 
 type Team struct {
@@ -20,13 +38,90 @@ type TeamWithMembers struct {
 	Members  []User `json:"members"`
 }
 
+// ReviewSLA SLA ревью команды автора PR: RemindAfter после назначения ревьюверу напоминают,
+// EscalateAfter - эскалация (Escalation: lead - уведомить LeadID, reassign - переназначить). Длительности Go, "24h"
+type ReviewSLA struct {
+	TeamName      string    `json:"team_name" gorm:"primaryKey"`
+	RemindAfter   string    `json:"remind_after"`
+	EscalateAfter string    `json:"escalate_after"`
+	Escalation    string    `json:"escalation"`
+	LeadID        *string   `json:"lead_id,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type RenameTeam struct {
+	TeamName    string `json:"team_name"`
+	NewTeamName string `json:"new_team_name"`
+}
+
+type DeleteTeam struct {
+	TeamName       string `json:"team_name"`
+	TargetTeamName string `json:"target_team_name,omitempty"`
+}
+
+type ReactivateTeam struct {
+	TeamName       string `json:"team_name"`
+	RestoreReviews bool   `json:"restore_reviews"`
+}
+
+type ReactivatedTeam struct {
+	TeamName        string       `json:"team_name"`
+	Members         []User       `json:"members"`
+	RestoredReviews []PrReviewer `json:"restored_reviews"`
+}
+
+type DeletedTeam struct {
+	TeamName     string `json:"team_name"`
+	MovedMembers []User `json:"moved_members"`
+}
+
+type OrgChart struct {
+	Teams []OrgTeam `json:"teams" yaml:"teams"`
+}
+
+type OrgTeam struct {
+	Name    string      `json:"team_name" yaml:"name"`
+	Members []OrgMember `json:"members" yaml:"members"`
+}
+
+type OrgMember struct {
+	UserID      string `json:"user_id" yaml:"user_id"`
+	Username    string `json:"username" yaml:"username"`
+	IsActive    *bool  `json:"is_active,omitempty" yaml:"is_active"`
+	AccountType string `json:"account_type,omitempty" yaml:"account_type"`
+}
+
+type SyncPlan struct {
+	CreateTeams     []string         `json:"create_teams"`
+	CreateUsers     []User           `json:"create_users"`
+	UpdateUsers     []UpdateUserInfo `json:"update_users"`
+	ActivateUsers   []string         `json:"activate_users"`
+	DeactivateUsers []string         `json:"deactivate_users"`
+	DryRun          bool             `json:"dry_run"`
+	Prune           bool             `json:"prune"`
+}
+
+type UserIdentity struct {
+	UserID     string    `json:"user_id"`
+	Provider   string    `json:"provider"`
+	ExternalID string    `json:"external_id"`
+	CreatedAt  time.Time `json:"-"`
+}
+
+type ExternalIdentity struct {
+	Provider   string `json:"provider"`
+	ExternalID string `json:"external_id"`
+}
+
 type PullRequest struct {
-	ID        string     `json:"pull_request_id"`
-	Name      string     `json:"pull_request_name"`
-	AuthorID  string     `json:"author_id"`
-	Status    string     `json:"status"`
-	CreatedAt time.Time  `json:"-"`
-	MergedAt  *time.Time `json:"merged_at,omitempty" gorm:"type:timestamptz"`
+	ID             string            `json:"pull_request_id"`
+	Name           string            `json:"pull_request_name"`
+	AuthorID       string            `json:"author_id"`
+	AuthorIdentity *ExternalIdentity `json:"author_identity,omitempty" gorm:"-"`
+	Repository     string            `json:"repository,omitempty"`
+	Status         string            `json:"status"`
+	CreatedAt      time.Time         `json:"created_at"`
+	MergedAt       *time.Time        `json:"merged_at,omitempty" gorm:"type:timestamptz"`
 }
 
 type UsersReviews struct {
@@ -35,8 +130,17 @@ type UsersReviews struct {
 }
 
 type PrReviewer struct {
+	PullRequestID string     `json:"pull_request_id"`
+	ReviewerID    string     `json:"reviewer_id"`
+	AssignedAt    time.Time  `json:"assigned_at" gorm:"default:now()"`
+	Verdict       *string    `json:"verdict,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
+type ReviewVerdict struct {
 	PullRequestID string `json:"pull_request_id"`
 	ReviewerID    string `json:"reviewer_id"`
+	Verdict       string `json:"verdict"`
 }
 
 type Review struct {
@@ -49,32 +153,206 @@ type UpdateReviewer struct {
 	OldReviewerID string `json:"old_reviewer_id"`
 }
 
+// Reassignment NewReviewerID пустой, если кандидата не нашлось и ревьювер просто снят
+type Reassignment struct {
+	PullRequestID string `json:"pull_request_id"`
+	OldReviewerID string `json:"old_reviewer_id"`
+	NewReviewerID string `json:"new_reviewer_id,omitempty"`
+}
+
 type UpdatedPR struct {
 	PullRequest
 	AssignedReviewers []string `json:"assigned_reviewers"`
 	ReplacedBy        string   `json:"replaced_by"`
 }
 
+type StatFilter struct {
+	From     *time.Time
+	To       *time.Time
+	Bucket   string
+	TeamName string
+}
+
 type GeneralStats struct {
-	UsersStat []UsersStat `json:"users_stat"`
-	PRStats   PRStats     `json:"pr_stats"`
+	From             *time.Time  `json:"from,omitempty"`
+	To               *time.Time  `json:"to,omitempty"`
+	UsersStat        []UsersStat `json:"users_stat"`
+	PRStats          PRStats     `json:"pr_stats"`
+	UsersStatBuckets []UsersStat `json:"users_stat_buckets,omitempty"`
+	PRStatsBuckets   []PRStats   `json:"pr_stats_buckets,omitempty"`
 }
 
 type UsersStat struct {
-	UserID  string `json:"user_id"`
-	PRCount int64  `json:"pr_count"`
+	Bucket  *time.Time `json:"bucket,omitempty"`
+	UserID  string     `json:"user_id"`
+	PRCount int64      `json:"pr_count"`
 }
 
 type PRStats struct {
-	Total int64 `json:"total"`
-	Open  int64 `json:"open"`
-	Merge int64 `json:"merge"`
+	Bucket      *time.Time `json:"bucket,omitempty"`
+	Total       int64      `json:"total"`
+	Open        int64      `json:"open"`
+	Merge       int64      `json:"merge"`
+	BotAuthored int64      `json:"bot_authored"`
 }
 
 type UserStat struct {
 	User
-	PullRequestsCount  int64 `json:"pull_requests_count"`
-	ReviewsCount       int64 `json:"reviews_count"`
-	MergedReviewsCount int64 `json:"merged_reviews_count"`
-	OpenReviewsCount   int64 `json:"open_reviews_count"`
+	PullRequestsCount  int64            `json:"pull_requests_count"`
+	ReviewsCount       int64            `json:"reviews_count"`
+	MergedReviewsCount int64            `json:"merged_reviews_count"`
+	OpenReviewsCount   int64            `json:"open_reviews_count"`
+	Buckets            []UserStatBucket `json:"buckets,omitempty" gorm:"-"`
+}
+
+type TeamStat struct {
+	TeamName             string           `json:"team_name"`
+	MembersCount         int64            `json:"members_count"`
+	InactiveMembersCount int64            `json:"inactive_members_count"`
+	OpenPRs              int64            `json:"open_prs"`
+	MergedPRs            int64            `json:"merged_prs"`
+	ReviewsCount         int64            `json:"reviews_count"`
+	Members              []TeamMemberStat `json:"members" gorm:"-"`
+}
+
+type TeamMemberStat struct {
+	UserID           string  `json:"user_id"`
+	Username         string  `json:"username"`
+	IsActive         bool    `json:"is_active"`
+	ReviewsCount     int64   `json:"reviews_count"`
+	OpenReviewsCount int64   `json:"open_reviews_count"`
+	ReviewShare      float64 `json:"review_share"`
+}
+
+type UserStatBucket struct {
+	Bucket             time.Time `json:"bucket"`
+	PullRequestsCount  int64     `json:"pull_requests_count"`
+	ReviewsCount       int64     `json:"reviews_count"`
+	MergedReviewsCount int64     `json:"merged_reviews_count"`
+	OpenReviewsCount   int64     `json:"open_reviews_count"`
+}
+
+type TurnaroundRow struct {
+	Key    string
+	Metric string
+	Count  int64
+	P50    float64
+	P90    float64
+}
+
+type DurationStat struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_seconds"`
+	P90   float64 `json:"p90_seconds"`
+}
+
+type TurnaroundStat struct {
+	Key               string       `json:"key"`
+	LeadTime          DurationStat `json:"lead_time"`
+	TimeToFirstReview DurationStat `json:"time_to_first_review"`
+	ResponseTime      DurationStat `json:"reviewer_response_time"`
+}
+
+type TurnaroundStats struct {
+	GroupBy string           `json:"group_by"`
+	From    *time.Time       `json:"from,omitempty"`
+	To      *time.Time       `json:"to,omitempty"`
+	Items   []TurnaroundStat `json:"items"`
+}
+
+type FairnessReport struct {
+	From   *time.Time     `json:"from,omitempty"`
+	To     *time.Time     `json:"to,omitempty"`
+	Bucket string         `json:"bucket"`
+	Teams  []TeamFairness `json:"teams"`
+}
+
+type TeamFairness struct {
+	TeamName         string           `json:"team_name"`
+	ActiveMembers    int              `json:"active_members"`
+	TotalAssignments int64            `json:"total_assignments"`
+	Mean             float64          `json:"mean"`
+	StdDev           float64          `json:"std_dev"`
+	Gini             float64          `json:"gini"`
+	MaxMinRatio      *float64         `json:"max_min_ratio"`
+	Members          []MemberFairness `json:"members"`
+}
+
+type MemberFairness struct {
+	UserID       string  `json:"user_id"`
+	Assignments  int64   `json:"assignments"`
+	Deviation    float64 `json:"deviation"`
+	OverBuckets  int     `json:"over_buckets"`
+	UnderBuckets int     `json:"under_buckets"`
+	Flag         string  `json:"flag,omitempty"`
+}
+
+type ReviewPair struct {
+	ReviewerID string `json:"reviewer_id"`
+	AuthorID   string `json:"author_id"`
+	Count      int64  `json:"count"`
+}
+
+type MutualPair struct {
+	UserA   string `json:"user_a"`
+	UserB   string `json:"user_b"`
+	AReview int64  `json:"a_reviews_b"`
+	BReview int64  `json:"b_reviews_a"`
+}
+
+// ReviewMatrix Counts[i][j] - сколько раз Reviewers[i] ревьюил PR автора Authors[j]
+type ReviewMatrix struct {
+	From        *time.Time   `json:"from,omitempty"`
+	To          *time.Time   `json:"to,omitempty"`
+	TeamName    string       `json:"team_name,omitempty"`
+	Reviewers   []string     `json:"reviewers"`
+	Authors     []string     `json:"authors"`
+	Counts      [][]int64    `json:"counts"`
+	Pairs       []ReviewPair `json:"pairs"`
+	MutualPairs []MutualPair `json:"mutual_pairs"`
+}
+
+// ReportReview ожидание - от назначения до ревью, а если его не было - до merge или текущего момента
+type ReportReview struct {
+	PullRequestID   string     `json:"pull_request_id"`
+	PullRequestName string     `json:"pull_request_name"`
+	ReviewerID      string     `json:"reviewer_id"`
+	AssignedAt      time.Time  `json:"assigned_at"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	Verdict         *string    `json:"verdict,omitempty"`
+	WaitSeconds     float64    `json:"wait_seconds"`
+}
+
+type StalePR struct {
+	PullRequest
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+type TeamReport struct {
+	TeamName       string         `json:"team_name"`
+	MergedPRs      []PullRequest  `json:"merged_prs"`
+	SlowestReviews []ReportReview `json:"slowest_reviews"`
+	Load           TeamStat       `json:"load"`
+	Gini           float64        `json:"gini"`
+	StalePRs       []StalePR      `json:"stale_prs"`
+}
+
+type Report struct {
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Teams       []TeamReport `json:"teams"`
+}
+
+// WebhookDelivery Status: PROCESSED - применен к PR, IGNORED - событие не требует действий (Reason)
+type WebhookDelivery struct {
+	Provider      string    `json:"provider"`
+	DeliveryID    string    `json:"delivery_id"`
+	Event         string    `json:"event"`
+	Action        string    `json:"action"`
+	PullRequestID string    `json:"pull_request_id,omitempty"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	Duplicate     bool      `json:"duplicate" gorm:"-"`
+	CreatedAt     time.Time `json:"received_at"`
 }
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// ChatWebhook incoming webhook Slack-совместимого чата. Задается либо для команды, либо для пользователя,
+// пользовательский важнее командного
+type ChatWebhook struct {
+	ID        int64     `json:"id"`
+	TeamName  *string   `json:"team_name,omitempty"`
+	UserID    *string   `json:"user_id,omitempty"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NotificationPreferences Channels - каналы (chat, email), пустой - уведомления выключены. EventTypes - события,
+// о которых уведомлять. DigestFrequency: daily, weekly (по понедельникам), off.
+// QuietStart, QuietEnd - "HH:MM" в Timezone. Уведомления, попавшие в тихие часы, откладываются до их конца
+type NotificationPreferences struct {
+	UserID          string    `json:"user_id" gorm:"primaryKey"`
+	Channels        []string  `json:"channels" gorm:"serializer:json"`
+	EventTypes      []string  `json:"event_types" gorm:"serializer:json"`
+	DigestFrequency string    `json:"digest_frequency"`
+	QuietStart      string    `json:"quiet_hours_start"`
+	QuietEnd        string    `json:"quiet_hours_end"`
+	Timezone        string    `json:"timezone"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Notification сообщение в очереди отправки. Target - url чата или email, Subject - только для email.
+// Status: PENDING, SENT, FAILED - попытки исчерпаны, SKIPPED - получатель отключил уведомление до отправки
+type Notification struct {
+	ID            int64      `json:"id"`
+	Channel       string     `json:"channel"`
+	UserID        string     `json:"user_id"`
+	Target        string     `json:"target"`
+	EventID       int64      `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Subject       string     `json:"subject,omitempty"`
+	Body          string     `json:"body"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ScimUserSchema       = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimGroupSchema      = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimEnterpriseSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	ScimListSchema       = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema      = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ScimUser команда пользователя передается через department enterprise-расширения
+type ScimUser struct {
+	Schemas    []string        `json:"schemas"`
+	ID         string          `json:"id,omitempty"`
+	ExternalID string          `json:"externalId,omitempty"`
+	UserName   string          `json:"userName"`
+	Active     *bool           `json:"active,omitempty"`
+	Enterprise *ScimEnterprise `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta       *ScimMeta       `json:"meta,omitempty"`
+}
+
+type ScimEnterprise struct {
+	Department string `json:"department,omitempty"`
+}
+
+type ScimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members"`
+	Meta        *ScimMeta    `json:"meta,omitempty"`
+}
+
+type ScimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimPatch struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []ScimPatchOp `json:"Operations"`
+}
+
+type ScimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
@@ -1,25 +1,91 @@
 package repository
 
-import "github.com/ashurov-imomali/pr-service/internal/models"
+import (
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"time"
+)
 
 type Repository interface {
+	Transaction(fn func(tx Repository) error) error
+	CreateTeam(teamName string) error
+	GetTeamNames() ([]string, error)
 	AddTeam(t *models.TeamWithMembers) error
 	GetTeam(teamName string) (*models.TeamWithMembers, bool, error)
 	UpdateUser(user *models.User) (bool, error)
 	GetUsersReview(userID string) (*models.UsersReviews, bool, error)
 	GetUserByID(id string) (*models.User, bool, error)
+	ListUsers(filter models.UserFilter) ([]models.User, error)
+	CountUsers(filter models.UserFilter) (int64, error)
+	CreateUser(user *models.User) error
+	UpdateUserInfo(info models.UpdateUserInfo) (*models.User, bool, error)
+	AddIdentity(identity *models.UserIdentity) error
+	DeleteIdentity(provider, externalID string) (bool, error)
+	GetIdentitiesByUserID(userID string) ([]models.UserIdentity, error)
+	GetActiveUserIdentities(provider string) ([]models.UserIdentity, error)
+	GetUserByIdentity(provider, externalID string) (*models.User, bool, error)
 	CreatePullRequest(request *models.PullRequest, users []models.PrReviewer) error
 	GetUsersIDByTeamName(teamName, authorID string) ([]string, bool, error)
-	UpdatePullRequest(pullRequest *models.PullRequest) (bool, error)
+	MergePullRequest(pullRequest *models.PullRequest) (bool, error)
 	GetUsersIDByReviewID(reviewID string) ([]string, error)
 	GetUsersIDByPRID(prID string) ([]string, error)
 	GetRandomUser(userID, prID string) (string, bool, error)
 	UpdateReviewer(prID, oldReviewerID, newReviewerID string) error
 	GetPullRequestByID(id string) (*models.PullRequest, bool, error)
 	GetReviewListByID(prID, userID string) (*models.PrReviewer, bool, error)
-	GetUsersStat() ([]models.UsersStat, error)
-	GetPRStats() (models.PRStats, error)
-	GetUserStat(id string) (*models.UserStat, error)
+	SetReviewVerdict(prID, reviewerID, verdict string) (*models.PrReviewer, bool, error)
+	GetTurnaround(groupBy string, filter models.StatFilter) ([]models.TurnaroundRow, error)
+	GetUsersStat(filter models.StatFilter) ([]models.UsersStat, error)
+	GetPRStats(filter models.StatFilter) ([]models.PRStats, error)
+	GetUserStat(id string, filter models.StatFilter) (*models.UserStat, error)
+	GetUserStatBuckets(id string, filter models.StatFilter) ([]models.UserStatBucket, error)
+	GetTeamStat(teamName string, filter models.StatFilter) (*models.TeamStat, error)
+	GetReviewPairs(filter models.StatFilter) ([]models.ReviewPair, error)
+	GetOpenPRCount() (int64, error)
+	GetOpenReviewsByUser() ([]models.UsersStat, error)
+	RebuildStats() error
+	GetMergedPRs(teamName string, filter models.StatFilter) ([]models.PullRequest, error)
+	GetSlowestReviews(teamName string, filter models.StatFilter, limit int) ([]models.ReportReview, error)
+	GetStalePRs(teamName string, createdBefore time.Time) ([]models.PullRequest, error)
+	GetWebhookDelivery(provider, deliveryID string) (*models.WebhookDelivery, bool, error)
+	ClaimWebhookDelivery(delivery *models.WebhookDelivery) (bool, error)
+	UpdateWebhookDelivery(delivery *models.WebhookDelivery) error
+	GetPullRequestTeam(prID string) (string, error)
+	CreateWebhookSubscription(sub *models.WebhookSubscription) error
+	ListWebhookSubscriptions() ([]models.WebhookSubscription, error)
+	GetWebhookSubscription(id int64) (*models.WebhookSubscription, bool, error)
+	DeleteWebhookSubscription(id int64) (bool, error)
+	CreateSubscriptionDeliveries(deliveries []models.SubscriptionDelivery) error
+	ListSubscriptionDeliveries(filter models.DeliveryFilter) ([]models.SubscriptionDelivery, error)
+	ClaimSubscriptionDeliveries(limit int, lease time.Duration) ([]models.SubscriptionDelivery, error)
+	UpdateSubscriptionDelivery(delivery *models.SubscriptionDelivery) error
+	ReplaySubscriptionDeliveries(replay models.ReplayDeliveries) (int64, error)
+	AddOutboxEvents(events []models.Event) error
+	LockOutbox() (bool, error)
+	GetPendingOutboxEvents(limit int) ([]models.OutboxEvent, error)
+	UpdateOutboxEvent(event *models.OutboxEvent) error
+	DeleteDeliveredOutboxEvents(before time.Time) (int64, error)
+	GetLatestOutboxEvents(limit int) ([]models.Event, error)
+	GetOutboxEventsAfter(id int64, limit int) ([]models.Event, error)
+
+	GetReviewSLA(teamName string) (*models.ReviewSLA, bool, error)
+	ListReviewSLAs() ([]models.ReviewSLA, error)
+	SaveReviewSLA(sla *models.ReviewSLA) error
+	DeleteReviewSLA(teamName string) (bool, error)
+	GetStaleReviews(teamName string, assignedBefore time.Time) ([]models.PrReviewer, error)
+	AddSLAAction(action *models.SLAAction) (bool, error)
+	SetChatWebhook(hook *models.ChatWebhook) error
+	DeleteChatWebhook(teamName, userID string) (bool, error)
+	ListChatWebhooks() ([]models.ChatWebhook, error)
+	GetChatWebhookURL(userID string) (string, error)
+	GetNotificationPreferences(userID string) (*models.NotificationPreferences, bool, error)
+	SaveNotificationPreferences(prefs *models.NotificationPreferences) error
+	CreateNotifications(notifications []models.Notification) error
+	ClaimNotifications(channel string, limit int, lease time.Duration) ([]models.Notification, error)
+	UpdateNotification(n *models.Notification) error
 	DeactivateTeam(teamName string) ([]models.User, bool, error)
+	ReactivateTeam(teamName string, restoreReviews bool) ([]models.User, []models.PrReviewer, bool, error)
+	AddReviewer(prID, reviewerID string) error
 	DeleteReviewer(userID, prID string) error
+	RenameTeam(oldName, newName string) (bool, error)
+	DeleteTeam(teamName, targetTeamName string) ([]models.User, bool, error)
 }
@@ -2,10 +2,13 @@ package repository
 
 import (
 	"errors"
+	"fmt"
 	"github.com/ashurov-imomali/pr-service/internal/models"
 	"golang.org/x/sync/errgroup"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"strings"
+	"time"
 )
 
 type repo struct {
@@ -16,6 +19,23 @@ func New(db *gorm.DB) Repository {
 	return &repo{db: db}
 }
 
+// Transaction выполняет fn в одной транзакции. Внутри fn нельзя вызывать методы,
+// которые открывают транзакцию через Begin (AddTeam, RenameTeam, DeleteTeam)
+func (r *repo) Transaction(fn func(tx Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repo{db: tx})
+	})
+}
+
+func (r *repo) CreateTeam(teamName string) error {
+	return r.db.Create(&models.Team{Name: teamName}).Error
+}
+
+func (r *repo) GetTeamNames() ([]string, error) {
+	var result []string
+	return result, r.db.Model(&models.Team{}).Order("name").Pluck("name", &result).Error
+}
+
 func (r *repo) AddTeam(t *models.TeamWithMembers) error {
 	tx := r.db.Begin()
 	team := models.Team{Name: t.TeamName}
@@ -100,31 +120,475 @@ func (r *repo) GetUserByID(id string) (*models.User, bool, error) {
 	return &result, false, nil
 }
 
-func (r *repo) CreatePullRequest(pr *models.PullRequest, reviewers []models.PrReviewer) error {
-	tx := r.db.Begin()
-	if err := tx.Create(pr).Error; err != nil {
-		tx.Rollback()
-		return err
+func (r *repo) ListUsers(filter models.UserFilter) ([]models.User, error) {
+	result := make([]models.User, 0)
+	tx := r.usersQuery(filter).Order("id")
+	if filter.Offset > 0 {
+		tx = tx.Offset(filter.Offset)
 	}
-	if len(reviewers) == 0 {
-		tx.Commit()
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+	return result, tx.Find(&result).Error
+}
+
+func (r *repo) CountUsers(filter models.UserFilter) (int64, error) {
+	var count int64
+	return count, r.usersQuery(filter).Count(&count).Error
+}
+
+func (r *repo) usersQuery(filter models.UserFilter) *gorm.DB {
+	tx := r.db.Model(&models.User{})
+	if filter.Username != "" {
+		if filter.ExactUsername {
+			tx = tx.Where("username=?", filter.Username)
+		} else {
+			tx = tx.Where("username ilike ?", "%"+filter.Username+"%")
+		}
+	}
+	if filter.TeamName != "" {
+		tx = tx.Where("team_name=?", filter.TeamName)
+	}
+	if filter.AccountType != "" {
+		tx = tx.Where("account_type=?", filter.AccountType)
+	}
+	if filter.IsActive != nil {
+		tx = tx.Where("is_active=?", *filter.IsActive)
+	}
+	return tx
+}
+
+func (r *repo) CreateUser(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *repo) UpdateUserInfo(info models.UpdateUserInfo) (*models.User, bool, error) {
+	fields := make(map[string]interface{})
+	if info.Username != "" {
+		fields["username"] = info.Username
+	}
+	if info.TeamName != "" {
+		fields["team_name"] = info.TeamName
+	}
+	if info.AccountType != "" {
+		fields["account_type"] = info.AccountType
+	}
+	fields["updated_at"] = gorm.Expr("now()")
+
+	var result models.User
+	tx := r.db.Model(&result).
+		Clauses(clause.Returning{}).
+		Where("id=?", info.ID).
+		Updates(fields)
+	if tx.Error != nil {
+		return nil, false, tx.Error
+	}
+	return &result, tx.RowsAffected == 0, nil
+}
+
+func (r *repo) AddIdentity(identity *models.UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *repo) DeleteIdentity(provider, externalID string) (bool, error) {
+	tx := r.db.Delete(&models.UserIdentity{}, "provider=? and external_id=?", provider, externalID)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 0, nil
+}
+
+func (r *repo) GetIdentitiesByUserID(userID string) ([]models.UserIdentity, error) {
+	result := make([]models.UserIdentity, 0)
+	return result, r.db.Where("user_id=?", userID).Order("provider, external_id").Find(&result).Error
+}
+
+// GetActiveUserIdentities идентичности провайдера у активных пользователей, например все email
+func (r *repo) GetActiveUserIdentities(provider string) ([]models.UserIdentity, error) {
+	var result []models.UserIdentity
+	return result, r.db.Table("user_identities i").Select("i.*").
+		Joins("join users u on u.id = i.user_id").
+		Where("i.provider=? and u.is_active", provider).
+		Order("i.user_id, i.external_id").Find(&result).Error
+}
+
+func (r *repo) GetUserByIdentity(provider, externalID string) (*models.User, bool, error) {
+	var result models.User
+	if err := r.db.Table("users u").Select("u.*").
+		Joins("join user_identities i on i.user_id = u.id").
+		Where("i.provider=? and i.external_id=?", provider, externalID).
+		Take(&result).Error; err != nil {
+		return nil, errors.Is(err, gorm.ErrRecordNotFound), err
+	}
+	return &result, false, nil
+}
+
+func (r *repo) GetWebhookDelivery(provider, deliveryID string) (*models.WebhookDelivery, bool, error) {
+	var result models.WebhookDelivery
+	if err := r.db.First(&result, "provider=? and delivery_id=?", provider, deliveryID).Error; err != nil {
+		return nil, errors.Is(err, gorm.ErrRecordNotFound), err
+	}
+	return &result, false, nil
+}
+
+// ClaimWebhookDelivery false - доставка уже записана. Параллельная повторная доставка ждет
+// на первичном ключе, пока первая транзакция не завершится
+func (r *repo) ClaimWebhookDelivery(delivery *models.WebhookDelivery) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(delivery)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *repo) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Model(&models.WebhookDelivery{}).
+		Where("provider=? and delivery_id=?", delivery.Provider, delivery.DeliveryID).
+		Updates(map[string]interface{}{
+			"action":          delivery.Action,
+			"pull_request_id": delivery.PullRequestID,
+			"status":          delivery.Status,
+			"reason":          delivery.Reason,
+		}).Error
+}
+
+// GetPullRequestTeam команда автора PR
+func (r *repo) GetPullRequestTeam(prID string) (string, error) {
+	var teamName string
+	return teamName, r.db.Table("pull_requests pr").
+		Select("u.team_name").
+		Joins("join users u on u.id = pr.author_id").
+		Where("pr.id = ?", prID).Scan(&teamName).Error
+}
+
+func (r *repo) CreateWebhookSubscription(sub *models.WebhookSubscription) error {
+	return r.db.Create(sub).Error
+}
+
+func (r *repo) ListWebhookSubscriptions() ([]models.WebhookSubscription, error) {
+	var result []models.WebhookSubscription
+	return result, r.db.Order("id").Find(&result).Error
+}
+
+func (r *repo) GetWebhookSubscription(id int64) (*models.WebhookSubscription, bool, error) {
+	var result models.WebhookSubscription
+	if err := r.db.First(&result, "id=?", id).Error; err != nil {
+		return nil, errors.Is(err, gorm.ErrRecordNotFound), err
+	}
+	return &result, false, nil
+}
+
+func (r *repo) DeleteWebhookSubscription(id int64) (bool, error) {
+	tx := r.db.Delete(&models.WebhookSubscription{}, "id=?", id)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 0, nil
+}
+
+// CreateSubscriptionDeliveries доставка события, уже поставленная подписке, пропускается
+func (r *repo) CreateSubscriptionDeliveries(deliveries []models.SubscriptionDelivery) error {
+	if len(deliveries) == 0 {
 		return nil
 	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
 
-	if err := tx.Create(&reviewers).Error; err != nil {
-		tx.Rollback()
-		return err
+func (r *repo) ListSubscriptionDeliveries(filter models.DeliveryFilter) ([]models.SubscriptionDelivery, error) {
+	tx := r.db.Model(&models.SubscriptionDelivery{})
+	if filter.SubscriptionID != 0 {
+		tx = tx.Where("subscription_id=?", filter.SubscriptionID)
+	}
+	if filter.Status != "" {
+		tx = tx.Where("status=?", filter.Status)
+	}
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
 	}
 
-	tx.Commit()
+	var result []models.SubscriptionDelivery
+	return result, tx.Order("id desc").Find(&result).Error
+}
+
+// ClaimSubscriptionDeliveries забирает доставки, которым пора уходить, и сдвигает их next_attempt_at на lease,
+// чтобы другие реплики их не взяли. Если процесс упадет во время отправки, доставка вернется после lease
+func (r *repo) ClaimSubscriptionDeliveries(limit int, lease time.Duration) ([]models.SubscriptionDelivery, error) {
+	var result []models.SubscriptionDelivery
+	return result, r.db.Raw(`
+    update subscription_deliveries
+    set next_attempt_at = now() + make_interval(secs => ?)
+    where id in (select id
+                 from subscription_deliveries
+                 where status = 'PENDING' and next_attempt_at <= now()
+                 order by id
+                 limit ?
+                 for update skip locked)
+    returning *`, lease.Seconds(), limit).Scan(&result).Error
+}
+
+func (r *repo) UpdateSubscriptionDelivery(delivery *models.SubscriptionDelivery) error {
+	return r.db.Model(delivery).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"last_error":      delivery.LastError,
+		"response_status": delivery.ResponseStatus,
+		"delivered_at":    delivery.DeliveredAt,
+	}).Error
+}
+
+// ReplaySubscriptionDeliveries возвращает FAILED доставки в очередь с нулевым счетчиком попыток
+func (r *repo) ReplaySubscriptionDeliveries(replay models.ReplayDeliveries) (int64, error) {
+	tx := r.db.Model(&models.SubscriptionDelivery{}).Where("status = 'FAILED'")
+	if replay.DeliveryID != 0 {
+		tx = tx.Where("id=?", replay.DeliveryID)
+	}
+	if replay.SubscriptionID != 0 {
+		tx = tx.Where("subscription_id=?", replay.SubscriptionID)
+	}
+	tx = tx.Updates(map[string]interface{}{
+		"status":          "PENDING",
+		"attempts":        0,
+		"next_attempt_at": gorm.Expr("now()"),
+		"last_error":      "",
+	})
+	return tx.RowsAffected, tx.Error
+}
+
+// outboxLockID ключ advisory lock релея outbox, чтобы события отправляла одна реплика
+const outboxLockID = 7_202_044
+
+// AddOutboxEvents вызывается внутри Transaction вместе с изменением, которое породило события
+func (r *repo) AddOutboxEvents(events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	rows := make([]models.OutboxEvent, 0, len(events))
+	for _, e := range events {
+		key := e.PullRequestID
+		if key == "" {
+			key = "team:" + e.TeamName
+		}
+		rows = append(rows, models.OutboxEvent{Event: e, OrderingKey: key, NextAttemptAt: e.OccurredAt})
+	}
+	if err := r.db.Create(&rows).Error; err != nil {
+		return err
+	}
+	for i := range rows {
+		events[i].ID = rows[i].ID
+	}
 	return nil
 }
 
+// LockOutbox блокировка до конца транзакции, false - релей уже работает в другой реплике
+func (r *repo) LockOutbox() (bool, error) {
+	var locked bool
+	return locked, r.db.Raw("select pg_try_advisory_xact_lock(?)", outboxLockID).Scan(&locked).Error
+}
+
+// GetPendingOutboxEvents неотправленные события, которым пора уходить, по порядку.
+// Событие пропускается, пока более раннее событие того же ключа ждет повтора
+func (r *repo) GetPendingOutboxEvents(limit int) ([]models.OutboxEvent, error) {
+	var result []models.OutboxEvent
+	return result, r.db.Raw(`
+    select o.*
+    from outbox_events o
+    where o.delivered_at is null
+      and o.next_attempt_at <= now()
+      and not exists (select 1
+                      from outbox_events p
+                      where p.ordering_key = o.ordering_key
+                        and p.delivered_at is null
+                        and p.id < o.id
+                        and p.next_attempt_at > now())
+    order by o.id
+    limit ?`, limit).Scan(&result).Error
+}
+
+func (r *repo) UpdateOutboxEvent(event *models.OutboxEvent) error {
+	return r.db.Model(event).Updates(map[string]interface{}{
+		"attempts":        event.Attempts,
+		"next_attempt_at": event.NextAttemptAt,
+		"last_error":      event.LastError,
+		"delivered_at":    event.DeliveredAt,
+	}).Error
+}
+
+func (r *repo) DeleteDeliveredOutboxEvents(before time.Time) (int64, error) {
+	tx := r.db.Where("delivered_at < ?", before).Delete(&models.OutboxEvent{})
+	return tx.RowsAffected, tx.Error
+}
+
+// GetLatestOutboxEvents последние limit событий (отправленных и нет) по возрастанию id
+func (r *repo) GetLatestOutboxEvents(limit int) ([]models.Event, error) {
+	var rows []models.OutboxEvent
+	if err := r.db.Order("id desc").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make([]models.Event, len(rows))
+	for i := range rows {
+		result[len(rows)-1-i] = rows[i].Event
+	}
+	return result, nil
+}
+
+func (r *repo) GetOutboxEventsAfter(id int64, limit int) ([]models.Event, error) {
+	var rows []models.OutboxEvent
+	if err := r.db.Where("id > ?", id).Order("id").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	result := make([]models.Event, len(rows))
+	for i := range rows {
+		result[i] = rows[i].Event
+	}
+	return result, nil
+}
+
+func (r *repo) GetReviewSLA(teamName string) (*models.ReviewSLA, bool, error) {
+	var result models.ReviewSLA
+	if err := r.db.First(&result, "team_name=?", teamName).Error; err != nil {
+		return nil, errors.Is(err, gorm.ErrRecordNotFound), err
+	}
+	return &result, false, nil
+}
+
+func (r *repo) ListReviewSLAs() ([]models.ReviewSLA, error) {
+	var result []models.ReviewSLA
+	return result, r.db.Order("team_name").Find(&result).Error
+}
+
+func (r *repo) SaveReviewSLA(sla *models.ReviewSLA) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(sla).Error
+}
+
+func (r *repo) DeleteReviewSLA(teamName string) (bool, error) {
+	tx := r.db.Where("team_name=?", teamName).Delete(&models.ReviewSLA{})
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 0, nil
+}
+
+// GetStaleReviews ревьюверы открытых PR команды, назначенные не позже assignedBefore и еще не оставившие вердикт
+func (r *repo) GetStaleReviews(teamName string, assignedBefore time.Time) ([]models.PrReviewer, error) {
+	var result []models.PrReviewer
+	return result, r.db.Table("pr_reviewers r").
+		Select("r.*").
+		Joins("join pull_requests p on p.id = r.pull_request_id").
+		Joins("join users u on u.id = p.author_id").
+		Where("u.team_name = ? and p.status = ? and r.reviewer_id is not null and r.verdict is null and r.assigned_at <= ?",
+			teamName, "OPEN", assignedBefore).
+		Order("r.assigned_at").Scan(&result).Error
+}
+
+// AddSLAAction false - шаг для этого назначения уже сработал (в т.ч. в другой реплике)
+func (r *repo) AddSLAAction(action *models.SLAAction) (bool, error) {
+	tx := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(action)
+	return tx.RowsAffected == 1, tx.Error
+}
+
+// SetChatWebhook заменяет webhook команды или пользователя
+func (r *repo) SetChatWebhook(hook *models.ChatWebhook) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		del := tx.Where("team_name=?", hook.TeamName)
+		if hook.UserID != nil {
+			del = tx.Where("user_id=?", hook.UserID)
+		}
+		if err := del.Delete(&models.ChatWebhook{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(hook).Error
+	})
+}
+
+func (r *repo) DeleteChatWebhook(teamName, userID string) (bool, error) {
+	tx := r.db.Where("team_name=?", teamName)
+	if userID != "" {
+		tx = r.db.Where("user_id=?", userID)
+	}
+	tx = tx.Delete(&models.ChatWebhook{})
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 0, nil
+}
+
+func (r *repo) ListChatWebhooks() ([]models.ChatWebhook, error) {
+	var result []models.ChatWebhook
+	return result, r.db.Order("id").Find(&result).Error
+}
+
+// GetChatWebhookURL webhook пользователя, если его нет - webhook его команды. Пустая строка - не настроено
+func (r *repo) GetChatWebhookURL(userID string) (string, error) {
+	var url string
+	return url, r.db.Raw(`
+    select h.url
+    from users u
+    join chat_webhooks h on h.user_id = u.id or h.team_name = u.team_name
+    where u.id = ?
+    order by h.user_id is null
+    limit 1`, userID).Scan(&url).Error
+}
+
+func (r *repo) GetNotificationPreferences(userID string) (*models.NotificationPreferences, bool, error) {
+	var result models.NotificationPreferences
+	if err := r.db.First(&result, "user_id=?", userID).Error; err != nil {
+		return nil, errors.Is(err, gorm.ErrRecordNotFound), err
+	}
+	return &result, false, nil
+}
+
+func (r *repo) SaveNotificationPreferences(prefs *models.NotificationPreferences) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(prefs).Error
+}
+
+// CreateNotifications повторы (тот же канал, пользователь и событие) пропускаются
+func (r *repo) CreateNotifications(notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notifications).Error
+}
+
+// ClaimNotifications как ClaimSubscriptionDeliveries, только для одного канала
+func (r *repo) ClaimNotifications(channel string, limit int, lease time.Duration) ([]models.Notification, error) {
+	var result []models.Notification
+	return result, r.db.Raw(`
+    update notifications
+    set next_attempt_at = now() + make_interval(secs => ?)
+    where id in (select id
+                 from notifications
+                 where channel = ? and status = 'PENDING' and next_attempt_at <= now()
+                 order by id
+                 limit ?
+                 for update skip locked)
+    returning *`, lease.Seconds(), channel, limit).Scan(&result).Error
+}
+
+func (r *repo) UpdateNotification(n *models.Notification) error {
+	return r.db.Model(n).Updates(map[string]interface{}{
+		"status":          n.Status,
+		"attempts":        n.Attempts,
+		"next_attempt_at": n.NextAttemptAt,
+		"last_error":      n.LastError,
+		"sent_at":         n.SentAt,
+	}).Error
+}
+
+func (r *repo) CreatePullRequest(pr *models.PullRequest, reviewers []models.PrReviewer) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(pr).Error; err != nil {
+			return err
+		}
+		if len(reviewers) == 0 {
+			return nil
+		}
+		return tx.Create(&reviewers).Error
+	})
+}
+
 func (r *repo) GetUsersIDByTeamName(teamName, authorID string) ([]string, bool, error) {
 	var ids []string
 
 	tx := r.db.Select("id").Table("users").
-		Where("is_active=? and team_name=? and id != ?", true, teamName, authorID).
+		Where("is_active=? and account_type=? and team_name=? and id != ?", true, "HUMAN", teamName, authorID).
 		Order("random()").Limit(2).Scan(&ids)
 	if tx.Error != nil {
 		return nil, false, tx.Error
@@ -132,9 +596,11 @@ func (r *repo) GetUsersIDByTeamName(teamName, authorID string) ([]string, bool,
 	return ids, tx.RowsAffected == 0, nil
 }
 
-func (r *repo) UpdatePullRequest(pullRequest *models.PullRequest) (bool, error) {
+// MergePullRequest меняет только открытый PR. true - PR нет или он уже смержен
+func (r *repo) MergePullRequest(pullRequest *models.PullRequest) (bool, error) {
 	tx := r.db.Model(&pullRequest).
 		Clauses(clause.Returning{}).
+		Where("status = ?", "OPEN").
 		Updates(map[string]interface{}{
 			"status":    pullRequest.Status,
 			"merged_at": pullRequest.MergedAt})
@@ -170,7 +636,7 @@ func (r *repo) GetRandomUser(userID, prID string) (string, bool, error) {
 		Joins("join users u2 on u1.team_name=u2.team_name and u2.id=?", userID).                     // беру тех кто из команды
 		Joins("left join pull_requests p on u1.id = p.author_id").                                   // исключаю автора
 		Joins("left join pr_reviewers pr on u1.id = pr.reviewer_id and pr.pull_request_id=?", prID). //исключаю тех кто уже как ревьюЕО стоят
-		Where("u1.is_active=? and pr.reviewer_id is null and p.id is null", true).
+		Where("u1.is_active=? and u1.account_type=? and pr.reviewer_id is null and p.id is null", true, "HUMAN").
 		Order("random()").Scan(&id)
 	if tx.Error != nil {
 		return "", false, tx.Error
@@ -181,7 +647,11 @@ func (r *repo) GetRandomUser(userID, prID string) (string, bool, error) {
 func (r *repo) UpdateReviewer(prID, oldReviewerID, newReviewerID string) error {
 	return r.db.Model(&models.PrReviewer{}).
 		Where("pull_request_id=? and reviewer_id=?", prID, oldReviewerID).
-		Update("reviewer_id", newReviewerID).Error
+		Updates(map[string]interface{}{
+			"reviewer_id": newReviewerID,
+			"assigned_at": gorm.Expr("now()"),
+			"verdict":     nil,
+			"reviewed_at": nil}).Error
 }
 
 func (r *repo) GetPullRequestByID(id string) (*models.PullRequest, bool, error) {
@@ -201,76 +671,443 @@ func (r *repo) GetReviewListByID(prID, userID string) (*models.PrReviewer, bool,
 	return &result, false, nil
 }
 
-func (r *repo) GetUsersStat() ([]models.UsersStat, error) {
+func (r *repo) SetReviewVerdict(prID, reviewerID, verdict string) (*models.PrReviewer, bool, error) {
+	var result models.PrReviewer
+	tx := r.db.Model(&result).
+		Clauses(clause.Returning{}).
+		Where("pull_request_id=? and reviewer_id=?", prID, reviewerID).
+		Updates(map[string]interface{}{
+			"verdict":     verdict,
+			"reviewed_at": gorm.Expr("coalesce(reviewed_at, now())")})
+	if tx.Error != nil {
+		return nil, false, tx.Error
+	}
+	return &result, tx.RowsAffected == 0, nil
+}
+
+// turnaroundKeys ключ группировки: для lead time и первого ревью - автор, для ответа ревьювера - ревьювер
+var turnaroundKeys = map[string][2]string{
+	"user":       {"pr.author_id", "p.reviewer_id"},
+	"team":       {"ua.team_name", "ur.team_name"},
+	"repository": {"pr.repository", "pr.repository"},
+}
+
+func (r *repo) GetTurnaround(groupBy string, filter models.StatFilter) ([]models.TurnaroundRow, error) {
+	keys, ok := turnaroundKeys[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown group %q", groupBy)
+	}
+	mergedCond, mergedArgs := statWindow("pr.merged_at", filter)
+	createdCond, createdArgs := statWindow("pr.created_at", filter)
+	assignedCond, assignedArgs := statWindow("p.assigned_at", filter)
+
+	args := append([]interface{}{}, mergedArgs...)
+	args = append(args, createdArgs...)
+	args = append(args, assignedArgs...)
+
+	var result []models.TurnaroundRow
+	return result, r.db.Raw(`
+    select key,
+           metric,
+           count(*) count,
+           percentile_cont(0.5) within group (order by seconds) p50,
+           percentile_cont(0.9) within group (order by seconds) p90
+    from (select `+keys[0]+` key,
+                 'lead_time' metric,
+                 extract(epoch from pr.merged_at - pr.created_at) seconds
+          from pull_requests pr
+          join users ua on ua.id = pr.author_id
+          where pr.status = 'MERGED' and pr.merged_at is not null and `+mergedCond+`
+          union all
+          select `+keys[0]+`,
+                 'time_to_first_review',
+                 extract(epoch from f.first_review - pr.created_at)
+          from pull_requests pr
+          join users ua on ua.id = pr.author_id
+          join (select pull_request_id, min(reviewed_at) first_review
+                from pr_reviewers
+                where reviewed_at is not null
+                group by pull_request_id) f on f.pull_request_id = pr.id
+          where `+createdCond+`
+          union all
+          select `+keys[1]+`,
+                 'response_time',
+                 extract(epoch from p.reviewed_at - p.assigned_at)
+          from pr_reviewers p
+          join pull_requests pr on pr.id = p.pull_request_id
+          join users ur on ur.id = p.reviewer_id
+          where p.reviewed_at is not null and `+assignedCond+`) s
+    group by key, metric
+    order by key, metric`, args...).Scan(&result).Error
+}
+
+func (r *repo) GetUsersStat(filter models.StatFilter) ([]models.UsersStat, error) {
 	var stat []models.UsersStat
-	return stat, r.db.Select("users.id user_id, count(pr.*) pr_count").Model(&models.User{}).
-		Joins("left join pr_reviewers pr on users.id = pr.reviewer_id").
-		Group("users.id").Find(&stat).Error
-}
-
-func (r *repo) GetPRStats() (models.PRStats, error) {
-	var result models.PRStats
-	return result, r.db.Select(`count(*) total,
-count(*) filter(where status = 'OPEN') open,
-count(*) filter(where status = 'MERGE') merged
-`).Model(&models.PullRequest{}).Find(&result).Error
-}
-
-func (r *repo) GetUserStat(id string) (*models.UserStat, error) {
-	//select u.id,
-	//       count(pr.*),
-	//       count(p.*),
-	//       count(p.*) filter (where pr1.status = 'MERGED'),
-	//       count(p.*) filter ( where pr1.status = 'OPEN')
-	//from users u
-	//left join pull_requests pr on u.id = pr.author_id
-	//left join pr_reviewers p on p.reviewer_id = u.id
-	//left join pull_requests pr1 on pr1.id = p.pull_request_id
-	//group by u.id;
+	cond, args := statDayWindow("s.day", filter)
+
+	tx := r.db.Model(&models.User{})
+	if filter.TeamName != "" {
+		tx = tx.Where("users.team_name=?", filter.TeamName)
+	}
+
+	if filter.Bucket == "" {
+		return stat, tx.Select("users.id user_id, coalesce(sum(s.reviews_assigned), 0) pr_count").
+			Joins("left join stats_user_daily s on users.id = s.user_id and "+cond, args...).
+			Group("users.id").Find(&stat).Error
+	}
+
+	return stat, tx.Select(dayBucket+" bucket, users.id user_id, sum(s.reviews_assigned) pr_count", filter.Bucket).
+		Joins("join stats_user_daily s on users.id = s.user_id and "+cond, args...).
+		Group("bucket, users.id").Having("sum(s.reviews_assigned) > 0").
+		Order("bucket, users.id").Find(&stat).Error
+}
+
+// GetPRStats total/open/bot_authored считаются по дню создания, merge - по дню merge.
+// Без bucket возвращается одна строка
+func (r *repo) GetPRStats(filter models.StatFilter) ([]models.PRStats, error) {
+	var result []models.PRStats
+	cond, args := statDayWindow("s.day", filter)
+	bucket := "null::timestamptz"
+	if filter.Bucket != "" {
+		bucket = dayBucket
+		args = append([]interface{}{filter.Bucket}, args...)
+	}
+
+	return result, r.db.Raw(`
+    select `+bucket+` bucket,
+           coalesce(sum(s.prs_created), 0) total,
+           coalesce(sum(s.prs_open), 0) open,
+           coalesce(sum(s.prs_merged), 0) merge,
+           coalesce(sum(s.prs_created) filter (where u.account_type = 'BOT'), 0) bot_authored
+    from stats_user_daily s
+    join users u on u.id = s.user_id
+    where `+cond+`
+    group by 1
+    having sum(s.prs_created) > 0 or sum(s.prs_merged) > 0
+    order by 1`, args...).Scan(&result).Error
+}
+
+func (r *repo) GetUserStat(id string, filter models.StatFilter) (*models.UserStat, error) {
+	cond, args := statDayWindow("s.day", filter)
+	args = append(args, id)
+
 	var result models.UserStat
-	return &result, r.db.Select(`u.*,
-	       count(pr.*) pull_requests_count,
-	       count(p.*) reviews_count,
-	       count(p.*) filter (where pr1.status = 'MERGED') merged_reviews_count,
-	       count(p.*) filter ( where pr1.status = 'OPEN') open_reviews_count`).
-		Table("users u").
-		Joins("left join pull_requests pr on u.id = pr.author_id").
-		Joins("left join pr_reviewers p on p.reviewer_id = u.id").
-		Joins("left join pull_requests pr1 on pr1.id = p.pull_request_id").
-		Where("u.id=?", id).
-		Group("u.id").Find(&result).Error
+	return &result, r.db.Raw(`
+    select u.*,
+           coalesce(s.pull_requests_count, 0) pull_requests_count,
+           coalesce(s.reviews_count, 0) reviews_count,
+           coalesce(s.merged_reviews_count, 0) merged_reviews_count,
+           coalesce(s.open_reviews_count, 0) open_reviews_count
+    from users u
+    cross join lateral (select sum(s.prs_created) pull_requests_count,
+                               sum(s.reviews_assigned) reviews_count,
+                               sum(s.reviews_merged) merged_reviews_count,
+                               sum(s.reviews_open) open_reviews_count
+                        from stats_user_daily s
+                        where s.user_id = u.id and `+cond+`) s
+    where u.id = ?`, args...).Scan(&result).Error
+}
+
+func (r *repo) GetUserStatBuckets(id string, filter models.StatFilter) ([]models.UserStatBucket, error) {
+	cond, args := statDayWindow("s.day", filter)
+	args = append([]interface{}{filter.Bucket, id}, args...)
+
+	var result []models.UserStatBucket
+	return result, r.db.Raw(`
+    select `+dayBucket+` bucket,
+           sum(s.prs_created) pull_requests_count,
+           sum(s.reviews_assigned) reviews_count,
+           sum(s.reviews_merged) merged_reviews_count,
+           sum(s.reviews_open) open_reviews_count
+    from stats_user_daily s
+    where s.user_id = ? and `+cond+`
+    group by 1
+    having sum(s.prs_created) > 0 or sum(s.reviews_assigned) > 0
+    order by 1`, args...).Scan(&result).Error
+}
+
+// GetTeamStat PR считаются по авторам, которые сейчас в команде
+func (r *repo) GetTeamStat(teamName string, filter models.StatFilter) (*models.TeamStat, error) {
+	result := models.TeamStat{TeamName: teamName}
+	cond, args := statDayWindow("s.day", filter)
+
+	var prs struct {
+		OpenPRs   int64
+		MergedPRs int64
+	}
+
+	gr := errgroup.Group{}
+	gr.Go(func() error {
+		return r.db.Raw(`
+    select coalesce(sum(s.prs_open), 0) open_prs,
+           coalesce(sum(s.prs_created - s.prs_open), 0) merged_prs
+    from stats_team_daily s
+    where s.team_name = ? and `+cond, append([]interface{}{teamName}, args...)...).
+			Scan(&prs).Error
+	})
+
+	result.Members = make([]models.TeamMemberStat, 0)
+	gr.Go(func() error {
+		return r.db.Raw(`
+    select u.id user_id,
+           u.username,
+           u.is_active,
+           coalesce(sum(s.reviews_assigned), 0) reviews_count,
+           coalesce(sum(s.reviews_open), 0) open_reviews_count
+    from users u
+    left join stats_user_daily s on s.user_id = u.id and `+cond+`
+    where u.team_name = ?
+    group by u.id
+    order by u.id`, append(append([]interface{}{}, args...), teamName)...).
+			Scan(&result.Members).Error
+	})
+
+	if err := gr.Wait(); err != nil {
+		return nil, err
+	}
+	result.OpenPRs, result.MergedPRs = prs.OpenPRs, prs.MergedPRs
+	return &result, nil
+}
+
+// GetMergedPRs PR авторов, которые сейчас в команде, смерженные за период
+func (r *repo) GetMergedPRs(teamName string, filter models.StatFilter) ([]models.PullRequest, error) {
+	cond, args := statWindow("pr.merged_at", filter)
+	var result []models.PullRequest
+	return result, r.db.Table("pull_requests pr").
+		Select("pr.*").
+		Joins("join users u on u.id = pr.author_id").
+		Where("u.team_name = ? and pr.status = 'MERGED'", teamName).
+		Where(cond, args...).
+		Order("pr.merged_at").Scan(&result).Error
+}
+
+// GetSlowestReviews самые долгие ревью участников команды среди назначенных за период
+func (r *repo) GetSlowestReviews(teamName string, filter models.StatFilter, limit int) ([]models.ReportReview, error) {
+	cond, args := statWindow("p.assigned_at", filter)
+	var result []models.ReportReview
+	return result, r.db.Table("pr_reviewers p").
+		Select(`p.pull_request_id,
+       pr.name pull_request_name,
+       p.reviewer_id,
+       p.assigned_at,
+       p.reviewed_at,
+       p.verdict,
+       extract(epoch from coalesce(p.reviewed_at, pr.merged_at, now()) - p.assigned_at) wait_seconds`).
+		Joins("join pull_requests pr on pr.id = p.pull_request_id").
+		Joins("join users u on u.id = p.reviewer_id").
+		Where("u.team_name = ?", teamName).
+		Where(cond, args...).
+		Order("wait_seconds desc").Limit(limit).Scan(&result).Error
+}
+
+// GetStalePRs открытые PR авторов команды, созданные раньше createdBefore
+func (r *repo) GetStalePRs(teamName string, createdBefore time.Time) ([]models.PullRequest, error) {
+	var result []models.PullRequest
+	return result, r.db.Table("pull_requests pr").
+		Select("pr.*").
+		Joins("join users u on u.id = pr.author_id").
+		Where("u.team_name = ? and pr.status = 'OPEN' and pr.created_at < ?", teamName, createdBefore).
+		Order("pr.created_at").Scan(&result).Error
+}
+
+// RebuildStats пересчитывает дневные агрегаты с нуля по pull_requests и pr_reviewers
+func (r *repo) RebuildStats() error {
+	return r.db.Exec("select stats_rebuild()").Error
+}
+
+// GetReviewPairs при фильтре по команде берутся пары, где ревьювер или автор состоит в команде
+func (r *repo) GetReviewPairs(filter models.StatFilter) ([]models.ReviewPair, error) {
+	cond, args := statWindow("p.assigned_at", filter)
+	tx := r.db.Table("pr_reviewers p").
+		Select("p.reviewer_id, pr.author_id, count(*) count").
+		Joins("join pull_requests pr on pr.id = p.pull_request_id").
+		Where(cond, args...)
+	if filter.TeamName != "" {
+		tx = tx.Joins("join users ur on ur.id = p.reviewer_id").
+			Joins("join users ua on ua.id = pr.author_id").
+			Where("(ur.team_name = ? or ua.team_name = ?)", filter.TeamName, filter.TeamName)
+	}
+
+	var result []models.ReviewPair
+	return result, tx.Group("p.reviewer_id, pr.author_id").
+		Order("p.reviewer_id, pr.author_id").Scan(&result).Error
+}
+
+func (r *repo) GetOpenPRCount() (int64, error) {
+	var count int64
+	return count, r.db.Model(&models.PullRequest{}).Where("status=?", "OPEN").Count(&count).Error
+}
+
+func (r *repo) GetOpenReviewsByUser() ([]models.UsersStat, error) {
+	var result []models.UsersStat
+	return result, r.db.Table("pr_reviewers p").
+		Select("p.reviewer_id user_id, count(*) pr_count").
+		Joins("join pull_requests pr on pr.id = p.pull_request_id and pr.status = 'OPEN'").
+		Group("p.reviewer_id").Scan(&result).Error
+}
+
+// statWindow условие [from, to) по колонке. Если границы не заданы - true
+func statWindow(column string, filter models.StatFilter) (string, []interface{}) {
+	conds := []string{"true"}
+	var args []interface{}
+	if filter.From != nil {
+		conds = append(conds, column+" >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conds = append(conds, column+" < ?")
+		args = append(args, *filter.To)
+	}
+	return strings.Join(conds, " and "), args
+}
+
+// dayBucket начало периода по дню из дневных агрегатов, в UTC
+const dayBucket = "date_trunc(?, s.day::timestamp) at time zone 'UTC'"
+
+// statDayWindow то же, что statWindow, но для колонки с днем (UTC). Границы - начало дня, проверяет StatService
+func statDayWindow(column string, filter models.StatFilter) (string, []interface{}) {
+	conds := []string{"true"}
+	var args []interface{}
+	if filter.From != nil {
+		conds = append(conds, column+" >= ?::date")
+		args = append(args, filter.From.UTC().Format(time.DateOnly))
+	}
+	if filter.To != nil {
+		conds = append(conds, column+" < ?::date")
+		args = append(args, filter.To.UTC().Format(time.DateOnly))
+	}
+	return strings.Join(conds, " and "), args
 }
 
 func (r *repo) DeactivateTeam(teamName string) ([]models.User, bool, error) {
 	var result []models.User
-	tx := r.db.Begin()
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&result).
+			Clauses(clause.Returning{}).
+			Where("team_name=?", teamName).
+			Update("is_active", false).Error; err != nil {
+			return err
+		}
 
-	if err := tx.Model(&result).
-		Clauses(clause.Returning{}).
-		Where("team_name=?", teamName).
-		Update("is_active", false).Error; err != nil {
-		tx.Rollback()
+		for _, user := range result {
+			// запоминаем снятые назначения, чтобы их можно было вернуть при реактивации
+			if err := tx.Exec(`
+    with removed as (
+        delete from pr_reviewers p
+        using pull_requests pr
+        where p.pull_request_id = pr.id
+          and pr.status = 'OPEN'
+          and p.reviewer_id = ?
+        returning p.pull_request_id, p.reviewer_id
+    )
+    insert into team_deactivated_reviews (team_name, pull_request_id, reviewer_id)
+    select ?, pull_request_id, reviewer_id from removed
+    on conflict (pull_request_id, reviewer_id) do nothing;
+`, user.ID, teamName).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, false, err
 	}
+	return result, len(result) == 0, nil
+}
 
-	for _, user := range result {
-		if err := tx.Exec(`
-    delete from pr_reviewers p
-    using pull_requests pr
-    where p.pull_request_id = pr.id
-      and pr.status = 'OPEN'
-      and p.reviewer_id = ?;
-`, user.ID).Error; err != nil {
+func (r *repo) AddReviewer(prID, reviewerID string) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.PrReviewer{PullRequestID: prID, ReviewerID: reviewerID}).Error
+}
+
+func (r *repo) DeleteReviewer(userID, prID string) error {
+	return r.db.Delete(models.PrReviewer{}, "reviewer_id=? and pull_request_id=?", userID, prID).Error
+}
+
+func (r *repo) RenameTeam(oldName, newName string) (bool, error) {
+	tx := r.db.Begin()
+	// users.team_name обновится каскадом (on update cascade)
+	res := tx.Model(&models.Team{}).Where("name=?", oldName).Update("name", newName)
+	if res.Error != nil {
+		tx.Rollback()
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return true, nil
+	}
+	return false, tx.Commit().Error
+}
+
+func (r *repo) DeleteTeam(teamName, targetTeamName string) ([]models.User, bool, error) {
+	var moved []models.User
+	tx := r.db.Begin()
+
+	if targetTeamName != "" {
+		if err := tx.Model(&moved).
+			Clauses(clause.Returning{}).
+			Where("team_name=?", teamName).
+			Update("team_name", targetTeamName).Error; err != nil {
 			tx.Rollback()
 			return nil, false, err
 		}
-
 	}
 
-	tx.Commit()
-	return result, len(result) == 0, nil
+	// если в команде остались участники, удаление упадет на foreign key
+	res := tx.Delete(&models.Team{}, "name=?", teamName)
+	if res.Error != nil {
+		tx.Rollback()
+		return nil, false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, true, nil
+	}
+	return moved, false, tx.Commit().Error
 }
 
-func (r *repo) DeleteReviewer(userID, prID string) error {
-	return r.db.Delete(models.PrReviewer{}, "reviewer_id=? and pull_request_id=?", userID, prID).Error
+func (r *repo) ReactivateTeam(teamName string, restoreReviews bool) ([]models.User, []models.PrReviewer, bool, error) {
+	var users []models.User
+	restored := make([]models.PrReviewer, 0)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users).
+			Clauses(clause.Returning{}).
+			Where("team_name=?", teamName).
+			Update("is_active", true).Error; err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		if restoreReviews {
+			// возвращаем только на OPEN PR и только в свободные слоты (максимум 2 ревьювера)
+			if err := tx.Raw(`
+    insert into pr_reviewers (pull_request_id, reviewer_id)
+    select c.pull_request_id, c.reviewer_id
+    from (select d.pull_request_id,
+                 d.reviewer_id,
+                 row_number() over (partition by d.pull_request_id order by d.deactivated_at, d.reviewer_id) rn,
+                 (select count(*) from pr_reviewers p where p.pull_request_id = d.pull_request_id) assigned
+          from team_deactivated_reviews d
+          join pull_requests pr on pr.id = d.pull_request_id and pr.status = 'OPEN'
+          join users u on u.id = d.reviewer_id and u.team_name = d.team_name
+          where d.team_name = ?
+            and not exists (select 1
+                            from pr_reviewers p
+                            where p.pull_request_id = d.pull_request_id
+                              and p.reviewer_id = d.reviewer_id)) c
+    where c.rn <= 2 - c.assigned
+    returning pull_request_id, reviewer_id, assigned_at;
+`, teamName).Scan(&restored).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec("delete from team_deactivated_reviews where team_name=?", teamName).Error
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return users, restored, len(users) == 0, nil
 }
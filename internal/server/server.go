@@ -2,17 +2,19 @@ package server
 
 import (
 	"github.com/ashurov-imomali/pr-service/internal/api"
+	"github.com/ashurov-imomali/pr-service/pkg/metrics"
 	"net/http"
 	"time"
 )
 
-func NewServer(addr string, h *api.Handler) *http.Server {
+func NewServer(addr string, h *api.Handler, m *metrics.Metrics) *http.Server {
 	mux := http.NewServeMux()
 	h.RegisterRouters(mux)
+	mux.Handle("/metrics", m.Handler())
 
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      m.Middleware(mux),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
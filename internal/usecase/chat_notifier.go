@@ -0,0 +1,284 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NotificationData данные шаблона уведомления. User - получатель. Для напоминаний и эскалаций
+// OldReviewerID - ревьювер, не уложившийся в SLA, Waiting - сколько он уже назначен
+type NotificationData struct {
+	Event         models.Event
+	User          models.User
+	PullRequest   models.PullRequest
+	OldReviewerID string
+	NewReviewerID string
+	Waiting       time.Duration
+}
+
+// NotificationTemplates шаблоны text/template по типу события, заменяют шаблоны по умолчанию
+type NotificationTemplates struct {
+	Chat   map[string]string        `yaml:"chat"`
+	Email  map[string]EmailTemplate `yaml:"email"`
+	Digest EmailTemplate            `yaml:"digest"`
+}
+
+var notificationFuncs = template.FuncMap{
+	"duration":    formatDuration,
+	"slackEscape": slackEscape,
+}
+
+// slackEscape экранирует управляющие символы Slack mrkdwn: иначе имя PR вида <!channel> или <http://x|y>
+// превращается в упоминание или ссылку
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+var defaultChatTemplates = map[string]string{
+	models.EventReviewerAssigned: "{{slackEscape .User.Username}}, you were assigned to review *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`)",
+	models.EventReviewerReplaced: "{{if eq .User.ID .NewReviewerID}}{{slackEscape .User.Username}}, you were assigned to review *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`) instead of {{slackEscape .OldReviewerID}}" +
+		"{{else}}{{slackEscape .User.Username}}, you were unassigned from *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`), {{slackEscape .NewReviewerID}} reviews it now{{end}}",
+	models.EventPRMerged:       "{{slackEscape .User.Username}}, your pull request *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`) was merged",
+	models.EventReviewReminder: "{{slackEscape .User.Username}}, *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`) has been waiting for your review for {{duration .Waiting}}",
+	models.EventReviewEscalated: "{{slackEscape .User.Username}}, review of *{{slackEscape .PullRequest.Name}}* (`{{slackEscape .PullRequest.ID}}`) by {{slackEscape .OldReviewerID}} " +
+		"is overdue: waiting for {{duration .Waiting}}",
+}
+
+// ChatNotifier получатель событий outbox: ставит в очередь сообщения в Slack-совместимые incoming webhook'и
+// (назначение, замена ревьювера, merge PR автора) и отправляет их с учетом тихих часов
+type ChatNotifier struct {
+	repo      repository.Repository
+	l         logger.Logger
+	client    *http.Client
+	templates map[string]*template.Template
+}
+
+func NewChatNotifier(repo repository.Repository, l logger.Logger, templates map[string]string) (*ChatNotifier, error) {
+	parsed, err := parseNotificationTemplates(defaultChatTemplates, templates)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatNotifier{repo: repo, l: l, client: outboundClient(), templates: parsed}, nil
+}
+
+func (n *ChatNotifier) Name() string {
+	return "chat"
+}
+
+func (n *ChatNotifier) Send(_ context.Context, event models.Event) error {
+	tmpl, ok := n.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	notifications, err := buildNotifications(n.repo, "chat", event,
+		func(user *models.User) (string, error) {
+			return n.repo.GetChatWebhookURL(user.ID)
+		},
+		func(data NotificationData) (string, string, error) {
+			body, err := executeTemplate(tmpl, data)
+			return "", body, err
+		})
+	if err != nil {
+		return err
+	}
+	return n.repo.CreateNotifications(notifications)
+}
+
+func (n *ChatNotifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := dispatchNotifications(ctx, n.repo, n.l, "chat", n.post); err != nil {
+			n.l.Errorf("Error send chat notifications. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *ChatNotifier) post(ctx context.Context, notification *models.Notification) error {
+	body, _ := json.Marshal(map[string]string{"text": notification.Body})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notification.Target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// parseNotificationTemplates шаблоны по умолчанию, поверх них переопределенные. Пустой шаблон отключает уведомление
+func parseNotificationTemplates(defaults, overrides map[string]string) (map[string]*template.Template, error) {
+	sources := make(map[string]string, len(defaults))
+	for eventType, text := range defaults {
+		sources[eventType] = text
+	}
+	for eventType, text := range overrides {
+		if !slices.Contains(models.EventTypes, eventType) {
+			return nil, fmt.Errorf("unknown event type %q in templates", eventType)
+		}
+		sources[eventType] = text
+	}
+
+	result := make(map[string]*template.Template, len(sources))
+	for eventType, text := range sources {
+		if text == "" {
+			continue
+		}
+		tmpl, err := parseTemplate(eventType, text)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", eventType, err)
+		}
+		result[eventType] = tmpl
+	}
+	return result, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(notificationFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// buildNotifications по уведомлению на каждого активного получателя, у которого есть адрес в канале
+// и который включил в настройках канал и тип события. Попавшие в тихие часы получателя откладываются до их конца. render возвращает тему и текст
+func buildNotifications(repo repository.Repository, channel string, event models.Event,
+	target func(user *models.User) (string, error),
+	render func(data NotificationData) (string, string, error)) ([]models.Notification, error) {
+	recipients, data, err := notificationData(repo, event)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]models.Notification, 0, len(recipients))
+	for _, id := range recipients {
+		user, notFound, err := repo.GetUserByID(id)
+		if notFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsActive {
+			continue
+		}
+
+		prefs, err := userPreferences(repo, id)
+		if err != nil {
+			return nil, err
+		}
+		if !wantsNotification(prefs, channel, event.Type) {
+			continue
+		}
+
+		address, err := target(user)
+		if err != nil {
+			return nil, err
+		}
+		if address == "" {
+			continue
+		}
+
+		data.User = *user
+		subject, body, err := render(data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, models.Notification{
+			Channel:       channel,
+			UserID:        id,
+			Target:        address,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Subject:       subject,
+			Body:          body,
+			Status:        "PENDING",
+			NextAttemptAt: quietHoursEnd(prefs, now),
+		})
+	}
+	return result, nil
+}
+
+// notificationData получатели: назначенный ревьювер, старый и новый ревьюверы при замене, автор при merge,
+// ревьювер при напоминании, лид при эскалации
+func notificationData(repo repository.Repository, event models.Event) ([]string, NotificationData, error) {
+	data := NotificationData{Event: event}
+	var recipients []string
+	switch event.Type {
+	case models.EventReviewerAssigned:
+		var a models.ReviewerAssignment
+		if err := json.Unmarshal(event.Data, &a); err != nil {
+			return nil, data, err
+		}
+		recipients, data.NewReviewerID = []string{a.ReviewerID}, a.ReviewerID
+	case models.EventReviewerReplaced:
+		var r models.Reassignment
+		if err := json.Unmarshal(event.Data, &r); err != nil {
+			return nil, data, err
+		}
+		recipients, data.OldReviewerID, data.NewReviewerID = []string{r.OldReviewerID, r.NewReviewerID}, r.OldReviewerID, r.NewReviewerID
+	case models.EventPRMerged:
+		var review models.Review
+		if err := json.Unmarshal(event.Data, &review); err != nil {
+			return nil, data, err
+		}
+		recipients = []string{review.AuthorID}
+	case models.EventReviewReminder, models.EventReviewEscalated:
+		var a models.SLAAction
+		if err := json.Unmarshal(event.Data, &a); err != nil {
+			return nil, data, err
+		}
+		data.OldReviewerID, data.NewReviewerID, data.Waiting = a.ReviewerID, a.NewReviewerID, event.OccurredAt.Sub(a.AssignedAt)
+		switch {
+		case event.Type == models.EventReviewReminder:
+			recipients = []string{a.ReviewerID}
+		case a.Action == "lead":
+			recipients = []string{a.LeadID}
+		default:
+			// о переназначении уведомляет pr.reviewer_replaced
+			return nil, data, nil
+		}
+	default:
+		return nil, data, nil
+	}
+
+	pr, notFound, err := repo.GetPullRequestByID(event.PullRequestID)
+	if notFound {
+		return nil, data, nil
+	}
+	if err != nil {
+		return nil, data, err
+	}
+	data.PullRequest = *pr
+	return recipients, data, nil
+}
@@ -0,0 +1,367 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// SMTPConfig Username пустой - без авторизации (MailHog, локальный relay)
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// EmailTemplate тема и текст письма, оба text/template
+type EmailTemplate struct {
+	Subject string `yaml:"subject"`
+	Body    string `yaml:"body"`
+}
+
+// DigestData данные шаблона дайджеста. Reviews - открытые ревью, самые старые первыми
+type DigestData struct {
+	User    models.User
+	Date    time.Time
+	Reviews []DigestReview
+}
+
+type DigestReview struct {
+	models.PullRequest
+	Age time.Duration
+}
+
+var defaultEmailTemplates = map[string]EmailTemplate{
+	models.EventReviewerAssigned: {
+		Subject: "Review requested: {{.PullRequest.Name}}",
+		Body: "Hi {{.User.Username}},\n\nyou were assigned to review {{.PullRequest.Name}} ({{.PullRequest.ID}}" +
+			"{{with .PullRequest.Repository}}, {{.}}{{end}}).\n",
+	},
+	models.EventReviewerReplaced: {
+		Subject: "{{if eq .User.ID .NewReviewerID}}Review requested{{else}}Review unassigned{{end}}: {{.PullRequest.Name}}",
+		Body: "Hi {{.User.Username}},\n\n{{if eq .User.ID .NewReviewerID}}you were assigned to review {{.PullRequest.Name}} ({{.PullRequest.ID}}) instead of {{.OldReviewerID}}." +
+			"{{else}}you were unassigned from {{.PullRequest.Name}} ({{.PullRequest.ID}}), {{.NewReviewerID}} reviews it now.{{end}}\n",
+	},
+	models.EventReviewReminder: {
+		Subject: "Review reminder: {{.PullRequest.Name}}",
+		Body:    "Hi {{.User.Username}},\n\n{{.PullRequest.Name}} ({{.PullRequest.ID}}) has been waiting for your review for {{duration .Waiting}}.\n",
+	},
+	models.EventReviewEscalated: {
+		Subject: "Overdue review: {{.PullRequest.Name}}",
+		Body: "Hi {{.User.Username}},\n\nreview of {{.PullRequest.Name}} ({{.PullRequest.ID}}) by {{.OldReviewerID}} " +
+			"is overdue: waiting for {{duration .Waiting}}.\n",
+	},
+}
+
+var defaultDigestTemplate = EmailTemplate{
+	Subject: "Open reviews: {{len .Reviews}}",
+	Body: "Hi {{.User.Username}},\n\nyour open reviews on {{.Date.Format \"2006-01-02\"}}, oldest first:\n" +
+		"{{range .Reviews}}\n- {{.Name}} ({{.ID}}{{with .Repository}}, {{.}}{{end}}), open for {{duration .Age}}{{end}}\n",
+}
+
+type emailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// EmailNotifier получатель событий outbox: письмо ревьюверу сразу при назначении и, если включен,
+// ежедневный дайджест открытых ревью. Адрес - идентичность email пользователя (см. /users/identities)
+type EmailNotifier struct {
+	repo      repository.Repository
+	l         logger.Logger
+	cfg       SMTPConfig
+	templates map[string]emailTemplate
+	digest    emailTemplate
+}
+
+func NewEmailNotifier(repo repository.Repository, l logger.Logger, cfg SMTPConfig,
+	templates map[string]EmailTemplate, digest EmailTemplate) (*EmailNotifier, error) {
+	sources := make(map[string]EmailTemplate, len(defaultEmailTemplates))
+	for eventType, t := range defaultEmailTemplates {
+		sources[eventType] = t
+	}
+	for eventType, t := range templates {
+		if !slices.Contains(models.EventTypes, eventType) {
+			return nil, fmt.Errorf("unknown event type %q in email templates", eventType)
+		}
+		sources[eventType] = t
+	}
+
+	n := &EmailNotifier{repo: repo, l: l, cfg: cfg, templates: make(map[string]emailTemplate, len(sources))}
+	for eventType, t := range sources {
+		if t.Body == "" {
+			continue
+		}
+		parsed, err := parseEmailTemplate(eventType, t)
+		if err != nil {
+			return nil, err
+		}
+		n.templates[eventType] = parsed
+	}
+
+	if digest.Subject == "" {
+		digest.Subject = defaultDigestTemplate.Subject
+	}
+	if digest.Body == "" {
+		digest.Body = defaultDigestTemplate.Body
+	}
+	parsed, err := parseEmailTemplate("digest", digest)
+	if err != nil {
+		return nil, err
+	}
+	n.digest = parsed
+	return n, nil
+}
+
+func parseEmailTemplate(name string, t EmailTemplate) (emailTemplate, error) {
+	subject, err := parseTemplate(name+".subject", t.Subject)
+	if err != nil {
+		return emailTemplate{}, fmt.Errorf("template %s subject: %w", name, err)
+	}
+	body, err := parseTemplate(name+".body", t.Body)
+	if err != nil {
+		return emailTemplate{}, fmt.Errorf("template %s body: %w", name, err)
+	}
+	return emailTemplate{subject: subject, body: body}, nil
+}
+
+func (t emailTemplate) render(data interface{}) (string, string, error) {
+	subject, err := executeTemplate(t.subject, data)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := executeTemplate(t.body, data)
+	return subject, body, err
+}
+
+func (n *EmailNotifier) Name() string {
+	return "email"
+}
+
+func (n *EmailNotifier) Send(_ context.Context, event models.Event) error {
+	tmpl, ok := n.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	notifications, err := buildNotifications(n.repo, "email", event, n.address,
+		func(data NotificationData) (string, string, error) {
+			return tmpl.render(data)
+		})
+	if err != nil {
+		return err
+	}
+	return n.repo.CreateNotifications(notifications)
+}
+
+func (n *EmailNotifier) address(user *models.User) (string, error) {
+	identities, err := n.repo.GetIdentitiesByUserID(user.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, identity := range identities {
+		if identity.Provider == "email" {
+			return identity.ExternalID, nil
+		}
+	}
+	return "", nil
+}
+
+// Run отправка очереди писем. digestAt - время дайджеста "HH:MM" UTC, пустое - дайджест выключен
+func (n *EmailNotifier) Run(ctx context.Context, interval time.Duration, digestAt string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastDigest time.Time
+	for {
+		if digestAt != "" {
+			now := time.Now().UTC()
+			day := now.Truncate(24 * time.Hour)
+			if at, err := time.Parse("15:04", digestAt); err == nil && day.After(lastDigest) &&
+				now.Sub(day) >= time.Duration(at.Hour())*time.Hour+time.Duration(at.Minute())*time.Minute {
+				if err := n.EnqueueDigests(day); err != nil {
+					n.l.Errorf("Error enqueue email digests. Err %v", err)
+				} else {
+					lastDigest = day
+				}
+			}
+		}
+		if err := dispatchNotifications(ctx, n.repo, n.l, "email", n.deliver); err != nil {
+			n.l.Errorf("Error send email notifications. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnqueueDigests ставит дайджест за day каждому активному пользователю с email и открытыми ревью,
+// если дайджест за этот день включен в его настройках.
+// Повторный вызов за тот же день (перезапуск, другая реплика) писем не дублирует
+func (n *EmailNotifier) EnqueueDigests(day time.Time) error {
+	identities, err := n.repo.GetActiveUserIdentities("email")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dayKey, _ := strconv.ParseInt(day.Format("20060102"), 10, 64)
+	var notifications []models.Notification
+	seen := make(map[string]bool)
+	for _, identity := range identities {
+		if seen[identity.UserID] {
+			continue
+		}
+		seen[identity.UserID] = true
+
+		prefs, err := userPreferences(n.repo, identity.UserID)
+		if err != nil {
+			return err
+		}
+		if !wantsDigest(prefs, day) {
+			continue
+		}
+
+		data, err := n.digestData(identity.UserID, day, now)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		subject, body, err := n.digest.render(data)
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, models.Notification{
+			Channel:       "email",
+			UserID:        identity.UserID,
+			Target:        identity.ExternalID,
+			EventID:       dayKey,
+			EventType:     "digest",
+			Subject:       subject,
+			Body:          body,
+			Status:        "PENDING",
+			NextAttemptAt: quietHoursEnd(prefs, now),
+		})
+	}
+	return n.repo.CreateNotifications(notifications)
+}
+
+// digestData nil - у пользователя нет открытых ревью
+func (n *EmailNotifier) digestData(userID string, day, now time.Time) (*DigestData, error) {
+	user, notFound, err := n.repo.GetUserByID(userID)
+	if notFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reviews, _, err := n.repo.GetUsersReview(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := DigestData{User: *user, Date: day}
+	for _, pr := range reviews.PullRequests {
+		if pr.Status == "OPEN" {
+			data.Reviews = append(data.Reviews, DigestReview{PullRequest: pr, Age: now.Sub(pr.CreatedAt)})
+		}
+	}
+	if len(data.Reviews) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(data.Reviews, func(a, b DigestReview) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return &data, nil
+}
+
+func (n *EmailNotifier) deliver(ctx context.Context, notification *models.Notification) error {
+	msg := buildEmail(n.cfg.From, notification.Target, notification.Subject, notification.Body, time.Now())
+	return sendMail(ctx, n.cfg, notification.Target, msg)
+}
+
+func buildEmail(from, to, subject, body string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.Write(bytes.ReplaceAll(bytes.ReplaceAll([]byte(body), []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n")))
+	return buf.Bytes()
+}
+
+// sendMail как smtp.SendMail, но с таймаутом и отменой через ctx
+func sendMail(ctx context.Context, cfg SMTPConfig, to string, msg []byte) error {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return err
+	}
+	// From может быть с именем ("PR Service <pr@example.com>"), в конверт идет только адрес
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
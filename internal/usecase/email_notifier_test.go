@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+func TestBuildEmail(t *testing.T) {
+	date := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	msg := string(buildEmail("PR Service <pr@example.com>", "dev@example.com", "Ревью: Add cache",
+		"Hi u2,\n\nline one\r\nline two\n", date))
+
+	head, body, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no header/body separator:\n%q", msg)
+	}
+	for _, header := range []string{
+		"From: PR Service <pr@example.com>",
+		"To: dev@example.com",
+		"Subject: =?utf-8?q?=D0=A0=D0=B5=D0=B2=D1=8C=D1=8E:_Add_cache?=",
+		"Date: Mon, 02 Mar 2026 09:30:00 +0000",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	} {
+		if !strings.Contains(head+"\r\n", header+"\r\n") {
+			t.Errorf("header %q missing in:\n%s", header, head)
+		}
+	}
+	if body != "Hi u2,\r\n\r\nline one\r\nline two\r\n" {
+		t.Fatalf("body = %q", body)
+	}
+	if strings.Contains(strings.ReplaceAll(msg, "\r\n", ""), "\n") {
+		t.Fatalf("bare LF in message: %q", msg)
+	}
+}
+
+// smtpSession то, что получил тестовый SMTP сервер
+type smtpSession struct {
+	from, to string
+	data     string
+}
+
+// runSMTP минимальный SMTP сервер на одну сессию: EHLO без STARTTLS и AUTH, MAIL, RCPT, DATA, QUIT
+func runSMTP(t *testing.T) (string, <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		reply := func(lines ...string) {
+			for _, line := range lines {
+				w.WriteString(line + "\r\n")
+			}
+			w.Flush()
+		}
+
+		var s smtpSession
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.Fields(cmd + " ")[0]); verb {
+			case "EHLO", "HELO":
+				reply("250-localhost", "250 8BITMIME")
+			case "MAIL":
+				// net/smtp добавляет BODY=8BITMIME, раз сервер его объявил
+				s.from, _, _ = strings.Cut(strings.TrimPrefix(cmd, "MAIL FROM:"), " ")
+				reply("250 OK")
+			case "RCPT":
+				s.to = strings.TrimPrefix(cmd, "RCPT TO:")
+				reply("250 OK")
+			case "DATA":
+				reply("354 end with <CRLF>.<CRLF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				s.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				sessions <- s
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), sessions
+}
+
+func TestEmailDeliver(t *testing.T) {
+	addr, sessions := runSMTP(t)
+	n, err := NewEmailNotifier(newMemRepo(), logger.New(), SMTPConfig{Addr: addr, From: "PR Service <pr@example.com>"}, nil, EmailTemplate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notification := &models.Notification{Target: "dev@example.com", Subject: "Review requested: Add cache", Body: "Hi u2,\n"}
+	if err := n.deliver(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-sessions:
+		if s.from != "<pr@example.com>" {
+			t.Errorf("MAIL FROM = %s, want <pr@example.com>", s.from)
+		}
+		if s.to != "<dev@example.com>" {
+			t.Errorf("RCPT TO = %s, want <dev@example.com>", s.to)
+		}
+		if !strings.Contains(s.data, "From: PR Service <pr@example.com>\r\n") ||
+			!strings.Contains(s.data, "Subject: Review requested: Add cache\r\n") ||
+			!strings.HasSuffix(s.data, "\r\n\r\nHi u2,\r\n") {
+			t.Errorf("DATA = %q", s.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no SMTP session")
+	}
+}
+
+func TestEnqueueDigestsOncePerDay(t *testing.T) {
+	repo := newMemRepo()
+	repo.identities = []models.UserIdentity{
+		{UserID: "u2", Provider: "email", ExternalID: "u2@example.com"},
+		{UserID: "u3", Provider: "email", ExternalID: "u3@example.com"},
+	}
+	// u3 дайджест выключил
+	repo.prefs["u3"] = models.NotificationPreferences{UserID: "u3", Channels: []string{"email"}, DigestFrequency: "off", Timezone: "UTC"}
+	created := time.Now().Add(-48 * time.Hour)
+	repo.prs["pr-1"] = models.PullRequest{ID: "pr-1", Name: "Add cache", AuthorID: "u1", Status: "OPEN", CreatedAt: created}
+	repo.prs["pr-2"] = models.PullRequest{ID: "pr-2", Name: "Old", AuthorID: "u1", Status: "MERGED", CreatedAt: created}
+	repo.reviewers["pr-1"] = []models.PrReviewer{{PullRequestID: "pr-1", ReviewerID: "u2"}, {PullRequestID: "pr-1", ReviewerID: "u3"}}
+	repo.reviewers["pr-2"] = []models.PrReviewer{{PullRequestID: "pr-2", ReviewerID: "u2"}}
+
+	n, err := NewEmailNotifier(repo, logger.New(), SMTPConfig{From: "pr@example.com"}, nil, EmailTemplate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	// перезапуск или вторая реплика в тот же день
+	for range 2 {
+		if err := n.EnqueueDigests(day); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(repo.notifications) != 1 {
+		t.Fatalf("notifications = %+v, want one digest", repo.notifications)
+	}
+	got := repo.notifications[0]
+	if got.Channel != "email" || got.UserID != "u2" || got.Target != "u2@example.com" || got.EventType != "digest" || got.EventID != 20260302 {
+		t.Fatalf("digest = %+v", got)
+	}
+	if got.Subject != "Open reviews: 1" || !strings.Contains(got.Body, "- Add cache (pr-1), open for") || strings.Contains(got.Body, "Old") {
+		t.Fatalf("digest subject %q body %q", got.Subject, got.Body)
+	}
+
+	// следующий день - новый дайджест
+	if err := n.EnqueueDigests(day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.notifications) != 2 || repo.notifications[1].EventID != 20260303 {
+		t.Fatalf("notifications = %+v", repo.notifications)
+	}
+}
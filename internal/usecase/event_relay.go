@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"time"
+)
+
+const (
+	relayBatchSize = 100
+	// outboxRetention сколько хранить отправленные события
+	outboxRetention = 7 * 24 * time.Hour
+	outboxCleanup   = time.Hour
+)
+
+// EventSink получатель событий из outbox. Ошибка - событие будет отправлено повторно всем получателям,
+// поэтому Send должен переносить повторы (доставка at-least-once)
+type EventSink interface {
+	Name() string
+	Send(ctx context.Context, event models.Event) error
+}
+
+// EventRelay отправляет события из outbox получателям и помечает их отправленными.
+// Работает одна реплика (advisory lock), события одного PR уходят строго по порядку:
+// пока событие ждет повтора, следующие события того же PR не отправляются
+type EventRelay struct {
+	repo  repository.Repository
+	l     logger.Logger
+	sinks []EventSink
+}
+
+func NewEventRelay(repo repository.Repository, l logger.Logger, sinks ...EventSink) *EventRelay {
+	return &EventRelay{repo: repo, l: l, sinks: sinks}
+}
+
+func (s *EventRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var cleaned time.Time
+	for {
+		if _, err := s.RelayPending(ctx); err != nil {
+			s.l.Errorf("Error relay outbox. Err %v", err)
+		}
+		if time.Since(cleaned) >= outboxCleanup {
+			if _, err := s.repo.DeleteDeliveredOutboxEvents(time.Now().Add(-outboxRetention)); err != nil {
+				s.l.Errorf("Error in bd (delete outbox events). Err %v", err)
+			}
+			cleaned = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending отправляет события пачками, пока они есть. Возвращает число отправленных
+func (s *EventRelay) RelayPending(ctx context.Context) (int, error) {
+	var total int
+	for ctx.Err() == nil {
+		sent, more, err := s.relayBatch(ctx)
+		total += sent
+		if err != nil || !more {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// relayBatch отправка и отметки в одной транзакции: если процесс упадет посередине,
+// события останутся неотправленными и уйдут повторно
+func (s *EventRelay) relayBatch(ctx context.Context) (int, bool, error) {
+	var sent int
+	var more bool
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		locked, err := tx.LockOutbox()
+		if err != nil || !locked {
+			return err
+		}
+		events, err := tx.GetPendingOutboxEvents(relayBatchSize)
+		if err != nil {
+			return err
+		}
+		more = len(events) == relayBatchSize
+
+		blocked := make(map[string]bool)
+		for i := range events {
+			e := &events[i]
+			if blocked[e.OrderingKey] {
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			now := time.Now()
+			if err := s.send(ctx, e.Event); err != nil {
+				blocked[e.OrderingKey] = true
+				e.Attempts++
+				e.LastError = err.Error()
+				e.NextAttemptAt = now.Add(deliveryDelay(e.Attempts))
+				s.l.Warnf("Outbox event %d (%s) not sent, attempt %d: %v", e.ID, e.Type, e.Attempts, err)
+			} else {
+				e.LastError, e.DeliveredAt = "", &now
+				sent++
+			}
+			if err := tx.UpdateOutboxEvent(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return sent, more && sent > 0, err
+}
+
+func (s *EventRelay) send(ctx context.Context, event models.Event) error {
+	var errs []error
+	for _, sink := range s.sinks {
+		if err := sink.Send(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogSink пишет события в лог, удобно для отладки
+type LogSink struct {
+	l logger.Logger
+}
+
+func NewLogSink(l logger.Logger) *LogSink {
+	return &LogSink{l: l}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Send(_ context.Context, event models.Event) error {
+	s.l.Infof("Event %d %s pr=%s team=%s users=%v", event.ID, event.Type, event.PullRequestID, event.TeamName, event.UserIDs)
+	return nil
+}
@@ -0,0 +1,200 @@
+package usecase
+
+import (
+	"context"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// streamHistorySize сколько последних событий хранится для возобновления по Last-Event-ID
+	streamHistorySize = 1000
+	streamBatchSize   = 500
+	// streamBuffer события, которые подписчик может не успеть прочитать, дальше он отключается
+	streamBuffer = 256
+	// streamGapWait сколько ждать событие с пропущенным id: транзакция с меньшим id могла еще не закоммититься
+	streamGapWait = 5 * time.Second
+)
+
+// StreamFilter пустые поля не фильтруют
+type StreamFilter struct {
+	TeamName      string
+	UserID        string
+	PullRequestID string
+}
+
+func (f StreamFilter) match(event models.Event) bool {
+	return (f.TeamName == "" || event.TeamName == f.TeamName) &&
+		(f.UserID == "" || slices.Contains(event.UserIDs, f.UserID)) &&
+		(f.PullRequestID == "" || event.PullRequestID == f.PullRequestID)
+}
+
+// StreamSubscription Events закрывается, когда подписчик не успевает читать или сервис останавливается
+type StreamSubscription struct {
+	Events <-chan models.Event
+	events chan models.Event
+	filter StreamFilter
+}
+
+// EventStream раздает события outbox подписчикам /events/stream. Каждая реплика сама читает outbox,
+// поэтому клиенту все равно, к какой реплике он подключен. Хранит последние события для Last-Event-ID
+type EventStream struct {
+	repo repository.Repository
+	l    logger.Logger
+
+	mu       sync.Mutex
+	history  []models.Event
+	subs     map[*StreamSubscription]struct{}
+	cursor   int64
+	gapSince time.Time
+	closed   bool
+}
+
+func NewEventStream(repo repository.Repository, l logger.Logger) *EventStream {
+	return &EventStream{repo: repo, l: l, subs: make(map[*StreamSubscription]struct{})}
+}
+
+// Run читает новые события раз в interval. При остановке закрывает подписки, чтобы обработчики завершились
+func (s *EventStream) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer s.close()
+
+	loaded := false
+	for {
+		if !loaded {
+			if err := s.load(); err != nil {
+				s.l.Errorf("Error load event stream history. Err %v", err)
+			} else {
+				loaded = true
+			}
+		} else if err := s.poll(); err != nil {
+			s.l.Errorf("Error poll event stream. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// load заполняет историю последними событиями, дальше читаются только новые
+func (s *EventStream) load() error {
+	events, err := s.repo.GetLatestOutboxEvents(streamHistorySize)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = events
+	if len(events) > 0 {
+		s.cursor = events[len(events)-1].ID
+	}
+	return nil
+}
+
+func (s *EventStream) poll() error {
+	for {
+		s.mu.Lock()
+		cursor := s.cursor
+		s.mu.Unlock()
+
+		events, err := s.repo.GetOutboxEventsAfter(cursor, streamBatchSize)
+		if err != nil {
+			return err
+		}
+		if published := s.publish(events); !published || len(events) < streamBatchSize {
+			return nil
+		}
+	}
+}
+
+// publish раздает события по порядку id. На пропуске id останавливается до streamGapWait,
+// чтобы не потерять событие транзакции, закоммиченной позже следующей (или откатившейся)
+func (s *EventStream) publish(events []models.Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range events {
+		if e.ID > s.cursor+1 && s.cursor > 0 {
+			if s.gapSince.IsZero() {
+				s.gapSince = now
+			}
+			if now.Sub(s.gapSince) < streamGapWait {
+				return false
+			}
+		}
+		s.gapSince = time.Time{}
+		s.cursor = e.ID
+
+		s.history = append(s.history, e)
+		if len(s.history) > streamHistorySize {
+			s.history = slices.Delete(s.history, 0, len(s.history)-streamHistorySize)
+		}
+		for sub := range s.subs {
+			if !sub.filter.match(e) {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				s.l.Warnf("Event stream subscriber is too slow, disconnected")
+				delete(s.subs, sub)
+				close(sub.events)
+			}
+		}
+	}
+	return true
+}
+
+// Subscribe подписка на новые события. lastEventID > 0 - сначала пропущенные после него события из истории,
+// complete false - история уже не содержит все пропущенные события
+func (s *EventStream) Subscribe(filter StreamFilter, lastEventID int64) (sub *StreamSubscription, missed []models.Event, complete bool) {
+	events := make(chan models.Event, streamBuffer)
+	sub = &StreamSubscription{Events: events, events: events, filter: filter}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(events)
+		return sub, nil, true
+	}
+	s.subs[sub] = struct{}{}
+
+	if lastEventID <= 0 {
+		return sub, nil, true
+	}
+	complete = lastEventID >= s.cursor ||
+		len(s.history) > 0 && s.history[0].ID <= lastEventID+1
+	for _, e := range s.history {
+		if e.ID > lastEventID && filter.match(e) {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed, complete
+}
+
+func (s *EventStream) Unsubscribe(sub *StreamSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[sub]; ok {
+		delete(s.subs, sub)
+		close(sub.events)
+	}
+}
+
+func (s *EventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		delete(s.subs, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"time"
+)
+
+// Доменные события не публикуются напрямую: сервисы пишут их в outbox в той же транзакции,
+// что и изменение (tx.AddOutboxEvents), а EventRelay отправляет их получателям (EventSink)
+
+func newEvent(eventType, prID, teamName string, userIDs []string, data interface{}) models.Event {
+	raw, _ := json.Marshal(data)
+	return models.Event{
+		Type:          eventType,
+		PullRequestID: prID,
+		TeamName:      teamName,
+		UserIDs:       userIDs,
+		OccurredAt:    time.Now().UTC(),
+		Data:          raw,
+	}
+}
+
+// reviewEvents pr.created / pr.merged, для создания еще pr.reviewer_assigned на каждого ревьювера
+func reviewEvents(eventType string, review models.Review, teamName string) []models.Event {
+	users := append([]string{review.AuthorID}, review.AssignedReviewers...)
+	events := []models.Event{newEvent(eventType, review.ID, teamName, users, review)}
+	if eventType == models.EventPRCreated {
+		events = append(events, assignmentEvents(review.ID, teamName, review.AssignedReviewers)...)
+	}
+	return events
+}
+
+func assignmentEvents(prID, teamName string, reviewerIDs []string) []models.Event {
+	events := make([]models.Event, 0, len(reviewerIDs))
+	for _, id := range reviewerIDs {
+		events = append(events, newEvent(models.EventReviewerAssigned, prID, teamName, []string{id},
+			models.ReviewerAssignment{PullRequestID: prID, ReviewerID: id}))
+	}
+	return events
+}
+
+// reassignmentEvents pr.reviewer_replaced или pr.reviewer_removed, если замены не нашлось
+func reassignmentEvents(repo repository.Repository, reassigned []models.Reassignment) ([]models.Event, error) {
+	events := make([]models.Event, 0, len(reassigned))
+	for _, r := range reassigned {
+		teamName, err := repo.GetPullRequestTeam(r.PullRequestID)
+		if err != nil {
+			return nil, err
+		}
+		eventType, users := models.EventReviewerReplaced, []string{r.OldReviewerID, r.NewReviewerID}
+		if r.NewReviewerID == "" {
+			eventType, users = models.EventReviewerRemoved, []string{r.OldReviewerID}
+		}
+		events = append(events, newEvent(eventType, r.PullRequestID, teamName, users, r))
+	}
+	return events, nil
+}
+
+func teamDeactivatedEvent(teamName string, users []models.User) models.Event {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return newEvent(models.EventTeamDeactivated, "", teamName, ids,
+		models.TeamDeactivated{TeamName: teamName, Users: users})
+}
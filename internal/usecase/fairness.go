@@ -0,0 +1,179 @@
+package usecase
+
+import (
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// fairnessTolerance отклонение от среднего по команде, которое еще считается нормой
+	fairnessTolerance = 0.2
+	// fairnessConsistency доля периодов, в которых участник должен быть выше/ниже среднего, чтобы попасть во флаг
+	fairnessConsistency = 0.75
+)
+
+// GetFairness распределение назначений по активным участникам команды: std dev, коэффициент Джини,
+// max/min. Участники, которые в большинстве периодов (bucket) выше или ниже среднего, помечаются флагом
+func (s *StatService) GetFairness(teamNames []string, filter models.StatFilter) (*models.FairnessReport, int, *Error) {
+	if filter.Bucket == "" {
+		filter.Bucket = "week"
+	}
+	if status, wErr := validateDayWindow(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	if len(teamNames) == 0 {
+		names, err := s.repo.GetTeamNames()
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+		teamNames = names
+	}
+
+	result := models.FairnessReport{
+		From:   filter.From,
+		To:     filter.To,
+		Bucket: filter.Bucket,
+		Teams:  make([]models.TeamFairness, 0, len(teamNames)),
+	}
+	active := true
+	for _, teamName := range teamNames {
+		members, err := s.repo.ListUsers(models.UserFilter{TeamName: teamName, IsActive: &active, AccountType: "HUMAN"})
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+
+		teamFilter := filter
+		teamFilter.TeamName = teamName
+		buckets, err := s.repo.GetUsersStat(teamFilter)
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+
+		result.Teams = append(result.Teams, teamFairness(teamName, members, buckets))
+	}
+	return &result, http.StatusOK, nil
+}
+
+func teamFairness(teamName string, members []models.User, buckets []models.UsersStat) models.TeamFairness {
+	result := models.TeamFairness{
+		TeamName:      teamName,
+		ActiveMembers: len(members),
+		Members:       make([]models.MemberFairness, len(members)),
+	}
+	if len(members) == 0 {
+		return result
+	}
+
+	index := make(map[string]int, len(members))
+	for i, m := range members {
+		index[m.ID] = i
+		result.Members[i].UserID = m.ID
+	}
+
+	perBucket := make(map[time.Time][]int64)
+	for _, b := range buckets {
+		i, ok := index[b.UserID]
+		if !ok || b.Bucket == nil {
+			continue
+		}
+		counts, ok := perBucket[*b.Bucket]
+		if !ok {
+			counts = make([]int64, len(members))
+			perBucket[*b.Bucket] = counts
+		}
+		counts[i] += b.PRCount
+		result.Members[i].Assignments += b.PRCount
+	}
+
+	totals := make([]float64, len(members))
+	for i, m := range result.Members {
+		totals[i] = float64(m.Assignments)
+		result.TotalAssignments += m.Assignments
+	}
+	result.Mean, result.StdDev = meanStdDev(totals)
+	result.Gini = gini(totals)
+
+	minValue, maxValue := totals[0], totals[0]
+	for _, v := range totals {
+		minValue, maxValue = math.Min(minValue, v), math.Max(maxValue, v)
+	}
+	if minValue > 0 {
+		ratio := maxValue / minValue
+		result.MaxMinRatio = &ratio
+	}
+
+	for _, counts := range perBucket {
+		values := make([]float64, len(counts))
+		for i, c := range counts {
+			values[i] = float64(c)
+		}
+		mean, _ := meanStdDev(values)
+		for i, v := range values {
+			switch {
+			case v > mean*(1+fairnessTolerance):
+				result.Members[i].OverBuckets++
+			case v < mean*(1-fairnessTolerance):
+				result.Members[i].UnderBuckets++
+			}
+		}
+	}
+
+	for i := range result.Members {
+		m := &result.Members[i]
+		if result.Mean > 0 {
+			m.Deviation = (float64(m.Assignments) - result.Mean) / result.Mean
+		}
+		if len(perBucket) < 2 {
+			continue
+		}
+		threshold := fairnessConsistency * float64(len(perBucket))
+		switch {
+		case float64(m.OverBuckets) >= threshold:
+			m.Flag = "OVERLOADED"
+		case float64(m.UnderBuckets) >= threshold:
+			m.Flag = "UNDERLOADED"
+		}
+	}
+	return result
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
+
+// gini 0 - нагрузка распределена идеально ровно, 1 - все ревью у одного человека
+func gini(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var sum, weighted float64
+	for i, v := range sorted {
+		sum += v
+		weighted += float64(i+1) * v
+	}
+	if sum == 0 {
+		return 0
+	}
+	n := float64(len(sorted))
+	return 2*weighted/(n*sum) - (n+1)/n
+}
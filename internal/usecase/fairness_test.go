@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMeanStdDev(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []float64
+		mean, std float64
+	}{
+		{name: "empty", values: nil},
+		{name: "all zero", values: []float64{0, 0, 0}},
+		{name: "single", values: []float64{4}, mean: 4},
+		{name: "equal", values: []float64{3, 3, 3}, mean: 3},
+		{name: "spread", values: []float64{2, 4, 4, 4, 5, 5, 7, 9}, mean: 5, std: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, std := meanStdDev(tt.values)
+			if !almostEqual(mean, tt.mean) || !almostEqual(std, tt.std) {
+				t.Fatalf("meanStdDev = %v, %v, want %v, %v", mean, std, tt.mean, tt.std)
+			}
+		})
+	}
+}
+
+func TestGini(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{name: "empty", values: nil},
+		{name: "all zero", values: []float64{0, 0, 0}},
+		{name: "single", values: []float64{5}},
+		{name: "equal", values: []float64{2, 2, 2, 2}},
+		{name: "one has all", values: []float64{10, 0, 0, 0}, want: 0.75},
+		{name: "unsorted", values: []float64{3, 1, 2}, want: 2.0 / 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gini(tt.values); !almostEqual(got, tt.want) {
+				t.Fatalf("gini = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeamFairness(t *testing.T) {
+	day := func(i int) *time.Time {
+		d := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*i)
+		return &d
+	}
+	members := func(ids ...string) []models.User {
+		users := make([]models.User, len(ids))
+		for i, id := range ids {
+			users[i] = models.User{ID: id, TeamName: "backend", IsActive: true}
+		}
+		return users
+	}
+	// rows ряды по периодам: counts[i] - назначения i-го участника в периоде
+	rows := func(ids []string, counts ...[]int64) []models.UsersStat {
+		var stats []models.UsersStat
+		for b, row := range counts {
+			for i, c := range row {
+				stats = append(stats, models.UsersStat{Bucket: day(b), UserID: ids[i], PRCount: c})
+			}
+		}
+		return stats
+	}
+	ratio := func(v float64) *float64 { return &v }
+	trio := []string{"u1", "u2", "u3"}
+
+	tests := []struct {
+		name      string
+		members   []models.User
+		buckets   []models.UsersStat
+		total     int64
+		mean      float64
+		gini      float64
+		ratio     *float64
+		flags     map[string]string
+		over      map[string]int
+		under     map[string]int
+		deviation map[string]float64
+	}{
+		{
+			name: "no members",
+		},
+		{
+			name:    "all zero",
+			members: members(trio...),
+			buckets: rows(trio, []int64{0, 0, 0}, []int64{0, 0, 0}),
+		},
+		{
+			name:    "single member",
+			members: members("u1"),
+			buckets: rows([]string{"u1"}, []int64{3}, []int64{1}),
+			total:   4,
+			mean:    4,
+			ratio:   ratio(1),
+		},
+		{
+			name:      "min is zero",
+			members:   members("u1", "u2"),
+			buckets:   rows([]string{"u1", "u2"}, []int64{4, 0}),
+			total:     4,
+			mean:      2,
+			gini:      0.5,
+			over:      map[string]int{"u1": 1},
+			under:     map[string]int{"u2": 1},
+			deviation: map[string]float64{"u1": 1, "u2": -1},
+		},
+		{
+			name:    "one bucket is not enough for a flag",
+			members: members(trio...),
+			buckets: rows(trio, []int64{6, 1, 2}),
+			total:   9,
+			mean:    3,
+			gini:    2*(1*1+2*2+3*6)/(3*9.0) - 4/3.0,
+			ratio:   ratio(6),
+			over:    map[string]int{"u1": 1},
+			under:   map[string]int{"u2": 1, "u3": 1},
+		},
+		{
+			name:    "flags need three of four buckets",
+			members: members(trio...),
+			buckets: rows(trio,
+				[]int64{4, 1, 1},
+				[]int64{4, 1, 1},
+				[]int64{4, 2, 0},
+				[]int64{2, 2, 2},
+			),
+			total: 24,
+			mean:  8,
+			gini:  2*(1*4+2*6+3*14)/(3*24.0) - 4/3.0,
+			ratio: ratio(14.0 / 4),
+			flags: map[string]string{"u1": "OVERLOADED", "u3": "UNDERLOADED"},
+			over:  map[string]int{"u1": 3},
+			under: map[string]int{"u2": 2, "u3": 3},
+		},
+		{
+			name:    "rows of non-members and without bucket are ignored",
+			members: members("u1", "u2"),
+			buckets: append(rows([]string{"u1", "u2", "bot"}, []int64{2, 2, 50}),
+				models.UsersStat{UserID: "u1", PRCount: 7}),
+			total: 4,
+			mean:  2,
+			ratio: ratio(1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := teamFairness("backend", tt.members, tt.buckets)
+
+			if got.TeamName != "backend" || got.ActiveMembers != len(tt.members) || len(got.Members) != len(tt.members) {
+				t.Fatalf("team = %+v", got)
+			}
+			if got.TotalAssignments != tt.total || !almostEqual(got.Mean, tt.mean) || !almostEqual(got.Gini, tt.gini) {
+				t.Fatalf("total, mean, gini = %d, %v, %v, want %d, %v, %v",
+					got.TotalAssignments, got.Mean, got.Gini, tt.total, tt.mean, tt.gini)
+			}
+			switch {
+			case tt.ratio == nil && got.MaxMinRatio != nil:
+				t.Fatalf("max_min_ratio = %v, want null", *got.MaxMinRatio)
+			case tt.ratio != nil && (got.MaxMinRatio == nil || !almostEqual(*got.MaxMinRatio, *tt.ratio)):
+				t.Fatalf("max_min_ratio = %v, want %v", got.MaxMinRatio, *tt.ratio)
+			}
+			for _, m := range got.Members {
+				if m.Flag != tt.flags[m.UserID] {
+					t.Errorf("%s: flag %q, want %q", m.UserID, m.Flag, tt.flags[m.UserID])
+				}
+				if m.OverBuckets != tt.over[m.UserID] || m.UnderBuckets != tt.under[m.UserID] {
+					t.Errorf("%s: over/under %d/%d, want %d/%d", m.UserID,
+						m.OverBuckets, m.UnderBuckets, tt.over[m.UserID], tt.under[m.UserID])
+				}
+				if want, ok := tt.deviation[m.UserID]; ok && !almostEqual(m.Deviation, want) {
+					t.Errorf("%s: deviation %v, want %v", m.UserID, m.Deviation, want)
+				}
+			}
+		})
+	}
+}
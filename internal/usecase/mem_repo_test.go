@@ -0,0 +1,269 @@
+package usecase
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+)
+
+// memRepo репозиторий в памяти для путей PRService (создание, merge, переназначение) и уведомлений.
+// Остальные методы не реализованы и паникуют
+type memRepo struct {
+	repository.Repository
+
+	users         map[string]models.User
+	identities    []models.UserIdentity
+	prefs         map[string]models.NotificationPreferences
+	prs           map[string]models.PullRequest
+	reviewers     map[string][]models.PrReviewer
+	events        []models.Event
+	notifications []models.Notification
+	slas          []models.ReviewSLA
+	slaActions    map[string]bool
+
+	// now время назначения ревьювера, как default now() в БД
+	now func() time.Time
+}
+
+// newMemRepo команда backend: u1 автор, u2-u4 ревьюверы
+func newMemRepo() *memRepo {
+	r := &memRepo{
+		users:      make(map[string]models.User),
+		prefs:      make(map[string]models.NotificationPreferences),
+		prs:        make(map[string]models.PullRequest),
+		reviewers:  make(map[string][]models.PrReviewer),
+		slaActions: make(map[string]bool),
+		now:        time.Now,
+	}
+	for _, id := range []string{"u1", "u2", "u3", "u4"} {
+		r.users[id] = models.User{ID: id, Username: id, TeamName: "backend", IsActive: true, AccountType: "HUMAN"}
+	}
+	return r
+}
+
+func (r *memRepo) Transaction(fn func(tx repository.Repository) error) error {
+	return fn(r)
+}
+
+func (r *memRepo) GetUserByID(id string) (*models.User, bool, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &u, false, nil
+}
+
+// GetUsersIDByTeamName первые два активных участника по id вместо случайных
+func (r *memRepo) GetUsersIDByTeamName(teamName, authorID string) ([]string, bool, error) {
+	var ids []string
+	for _, id := range []string{"u1", "u2", "u3", "u4"} {
+		if u := r.users[id]; u.TeamName == teamName && u.IsActive && id != authorID && len(ids) < 2 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, len(ids) == 0, nil
+}
+
+func (r *memRepo) GetPullRequestByID(id string) (*models.PullRequest, bool, error) {
+	pr, ok := r.prs[id]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &pr, false, nil
+}
+
+func (r *memRepo) CreatePullRequest(pr *models.PullRequest, reviewers []models.PrReviewer) error {
+	if _, ok := r.prs[pr.ID]; ok {
+		return os.ErrExist
+	}
+	r.prs[pr.ID] = *pr
+	for _, rv := range reviewers {
+		if rv.AssignedAt.IsZero() {
+			rv.AssignedAt = r.now()
+		}
+		r.reviewers[pr.ID] = append(r.reviewers[pr.ID], rv)
+	}
+	return nil
+}
+
+func (r *memRepo) GetReviewListByID(prID, userID string) (*models.PrReviewer, bool, error) {
+	for _, rv := range r.reviewers[prID] {
+		if rv.ReviewerID == userID {
+			return &rv, false, nil
+		}
+	}
+	return nil, true, os.ErrNotExist
+}
+
+// GetRandomUser первый по id активный участник команды автора, кроме автора и ревьюверов PR
+func (r *memRepo) GetRandomUser(userID, prID string) (string, bool, error) {
+	pr := r.prs[prID]
+	for _, id := range []string{"u1", "u2", "u3", "u4"} {
+		u := r.users[id]
+		if _, _, err := r.GetReviewListByID(prID, id); err == nil || id == pr.AuthorID || id == userID ||
+			!u.IsActive || u.TeamName != r.users[pr.AuthorID].TeamName {
+			continue
+		}
+		return id, false, nil
+	}
+	return "", true, os.ErrNotExist
+}
+
+func (r *memRepo) UpdateReviewer(prID, oldReviewerID, newReviewerID string) error {
+	for i, rv := range r.reviewers[prID] {
+		if rv.ReviewerID == oldReviewerID {
+			r.reviewers[prID][i] = models.PrReviewer{PullRequestID: prID, ReviewerID: newReviewerID, AssignedAt: r.now()}
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) MergePullRequest(pr *models.PullRequest) (bool, error) {
+	existing, ok := r.prs[pr.ID]
+	if !ok || existing.Status != "OPEN" {
+		return true, nil
+	}
+	existing.Status, existing.MergedAt = pr.Status, pr.MergedAt
+	r.prs[pr.ID] = existing
+	*pr = existing
+	return false, nil
+}
+
+func (r *memRepo) GetUsersIDByReviewID(id string) ([]string, error) {
+	return r.GetUsersIDByPRID(id)
+}
+
+func (r *memRepo) GetUsersIDByPRID(id string) ([]string, error) {
+	var ids []string
+	for _, rv := range r.reviewers[id] {
+		ids = append(ids, rv.ReviewerID)
+	}
+	return ids, nil
+}
+
+func (r *memRepo) GetPullRequestTeam(prID string) (string, error) {
+	return r.users[r.prs[prID].AuthorID].TeamName, nil
+}
+
+func (r *memRepo) AddOutboxEvents(events []models.Event) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+// eventTypes типы записанных в outbox событий по порядку
+func (r *memRepo) eventTypes() []string {
+	types := make([]string, 0, len(r.events))
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func (r *memRepo) GetActiveUserIdentities(provider string) ([]models.UserIdentity, error) {
+	var result []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.Provider == provider && r.users[identity.UserID].IsActive {
+			result = append(result, identity)
+		}
+	}
+	return result, nil
+}
+
+func (r *memRepo) GetNotificationPreferences(userID string) (*models.NotificationPreferences, bool, error) {
+	prefs, ok := r.prefs[userID]
+	if !ok {
+		return nil, true, os.ErrNotExist
+	}
+	return &prefs, false, nil
+}
+
+func (r *memRepo) GetUsersReview(userID string) (*models.UsersReviews, bool, error) {
+	result := models.UsersReviews{UserID: userID}
+	for prID, reviewers := range r.reviewers {
+		for _, rv := range reviewers {
+			if rv.ReviewerID == userID {
+				result.PullRequests = append(result.PullRequests, r.prs[prID])
+			}
+		}
+	}
+	return &result, false, nil
+}
+
+// CreateNotifications как в БД: уникальный ключ (channel, user_id, event_type, event_id)
+func (r *memRepo) CreateNotifications(notifications []models.Notification) error {
+	for _, n := range notifications {
+		if !slices.ContainsFunc(r.notifications, func(e models.Notification) bool {
+			return e.Channel == n.Channel && e.UserID == n.UserID && e.EventType == n.EventType && e.EventID == n.EventID
+		}) {
+			n.ID = int64(len(r.notifications) + 1)
+			r.notifications = append(r.notifications, n)
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) ClaimNotifications(channel string, limit int, _ time.Duration) ([]models.Notification, error) {
+	var result []models.Notification
+	for _, n := range r.notifications {
+		if n.Channel == channel && n.Status == "PENDING" && !n.NextAttemptAt.After(time.Now()) && len(result) < limit {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+func (r *memRepo) UpdateNotification(n *models.Notification) error {
+	for i := range r.notifications {
+		if r.notifications[i].ID == n.ID {
+			r.notifications[i] = *n
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) ListReviewSLAs() ([]models.ReviewSLA, error) {
+	return r.slas, nil
+}
+
+func (r *memRepo) GetStaleReviews(teamName string, assignedBefore time.Time) ([]models.PrReviewer, error) {
+	var result []models.PrReviewer
+	for prID, reviewers := range r.reviewers {
+		pr := r.prs[prID]
+		if pr.Status != "OPEN" || r.users[pr.AuthorID].TeamName != teamName {
+			continue
+		}
+		for _, rv := range reviewers {
+			if rv.Verdict == nil && !rv.AssignedAt.After(assignedBefore) {
+				result = append(result, rv)
+			}
+		}
+	}
+	slices.SortFunc(result, func(a, b models.PrReviewer) int {
+		if c := a.AssignedAt.Compare(b.AssignedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ReviewerID, b.ReviewerID)
+	})
+	return result, nil
+}
+
+// AddSLAAction как в БД: первичный ключ (pull_request_id, reviewer_id, assigned_at, stage)
+func (r *memRepo) AddSLAAction(action *models.SLAAction) (bool, error) {
+	key := fmt.Sprintf("%s/%s/%d/%s", action.PullRequestID, action.ReviewerID, action.AssignedAt.UnixNano(), action.Stage)
+	if r.slaActions[key] {
+		return false, nil
+	}
+	r.slaActions[key] = true
+	return true, nil
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) NoCandidate(string)              {}
+func (nopMetrics) SetOpenPRs(int64)                {}
+func (nopMetrics) SetOpenReviews(map[string]int64) {}
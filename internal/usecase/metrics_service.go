@@ -0,0 +1,70 @@
+package usecase
+
+import (
+	"context"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"time"
+)
+
+type DomainMetrics interface {
+	NoCandidate(source string)
+	SetOpenPRs(count int64)
+	SetOpenReviews(counts map[string]int64)
+}
+
+// MetricsService периодически пересчитывает доменные gauge. Так scrape /metrics
+// не ходит в базу, а запросы идут по частичному индексу на OPEN PR
+type MetricsService struct {
+	repo repository.Repository
+	l    logger.Logger
+	m    DomainMetrics
+}
+
+func NewMetricsService(repo repository.Repository, l logger.Logger, m DomainMetrics) *MetricsService {
+	return &MetricsService{repo: repo, l: l, m: m}
+}
+
+func (s *MetricsService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Refresh(); err != nil {
+			s.l.Errorf("Error refresh metrics. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *MetricsService) Refresh() error {
+	openPRs, err := s.repo.GetOpenPRCount()
+	if err != nil {
+		return err
+	}
+	load, err := s.repo.GetOpenReviewsByUser()
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int64, len(load))
+	for _, u := range load {
+		counts[u.UserID] = u.PRCount
+	}
+	s.m.SetOpenPRs(openPRs)
+	s.m.SetOpenReviews(counts)
+	return nil
+}
+
+func countNoCandidate(m DomainMetrics, source string, reassigned []models.Reassignment) {
+	for _, r := range reassigned {
+		if r.NewReviewerID == "" {
+			m.NoCandidate(source)
+		}
+	}
+}
@@ -4,3 +4,8 @@ type Error struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 }
+
+// validAccountType BOT может быть автором PR, но не ревьювером
+func validAccountType(accountType string) bool {
+	return accountType == "HUMAN" || accountType == "BOT"
+}
@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"github.com/nats-io/nats.go"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NATSSink публикует события outbox в NATS: subject <prefix>.events.<type>, например pr-service.events.pr.merged.
+// Nats-Msg-Id = id события, JetStream по нему отбрасывает повторы
+type NATSSink struct {
+	nc     *nats.Conn
+	prefix string
+}
+
+func NewNATSSink(nc *nats.Conn, prefix string) *NATSSink {
+	return &NATSSink{nc: nc, prefix: prefix}
+}
+
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+// natsFlushTimeout FlushWithContext требует дедлайн, у контекста релея его нет
+const natsFlushTimeout = 5 * time.Second
+
+// Send ждет подтверждения сервера (flush), иначе событие могло остаться в буфере клиента
+func (s *NATSSink) Send(ctx context.Context, event models.Event) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, natsFlushTimeout)
+		defer cancel()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(s.prefix + ".events." + event.Type)
+	msg.Header.Set(nats.MsgIdHdr, strconv.FormatInt(event.ID, 10))
+	msg.Data = data
+	if err := s.nc.PublishMsg(msg); err != nil {
+		return err
+	}
+	return s.nc.FlushWithContext(ctx)
+}
+
+// CommandConsumer принимает команды из <prefix>.commands.pr.create и <prefix>.commands.pr.merge.
+// Тело - тот же JSON, что у /pullRequests/create и /pullRequests/merge. Если у сообщения есть reply,
+// туда уходит CommandReply
+type CommandConsumer struct {
+	nc     *nats.Conn
+	prs    *PRService
+	prefix string
+	l      logger.Logger
+}
+
+// CommandReply Status - http статус, который вернул бы соответствующий эндпоинт
+type CommandReply struct {
+	Status int            `json:"status"`
+	PR     *models.Review `json:"pr,omitempty"`
+	Error  *Error         `json:"error,omitempty"`
+}
+
+func NewCommandConsumer(nc *nats.Conn, prs *PRService, prefix string, l logger.Logger) *CommandConsumer {
+	return &CommandConsumer{nc: nc, prs: prs, prefix: prefix, l: l}
+}
+
+// Subscribe очередь prefix: каждую команду обрабатывает одна реплика
+func (c *CommandConsumer) Subscribe() (*nats.Subscription, error) {
+	return c.nc.QueueSubscribe(c.prefix+".commands.>", c.prefix, c.handle)
+}
+
+func (c *CommandConsumer) handle(msg *nats.Msg) {
+	reply := c.Execute(strings.TrimPrefix(msg.Subject, c.prefix+".commands."), msg.Data)
+	if reply.Error != nil {
+		c.l.Warnf("NATS command %s failed: %d %+v", msg.Subject, reply.Status, reply.Error)
+	}
+	if msg.Reply == "" {
+		return
+	}
+	data, _ := json.Marshal(reply)
+	if err := msg.Respond(data); err != nil {
+		c.l.Errorf("Error respond to NATS command %s. Err %v", msg.Subject, err)
+	}
+}
+
+// Execute command - pr.create или pr.merge
+func (c *CommandConsumer) Execute(command string, payload []byte) CommandReply {
+	var pr models.PullRequest
+	if err := json.Unmarshal(payload, &pr); err != nil {
+		return CommandReply{Status: http.StatusBadRequest, Error: &Error{Code: "INVALID_JSON"}}
+	}
+
+	var (
+		review *models.Review
+		status int
+		wErr   *Error
+	)
+	switch command {
+	case "pr.create":
+		review, status, wErr = c.prs.CreatePullRequest(pr)
+	case "pr.merge":
+		review, status, wErr = c.prs.MergePullRequest(pr)
+	default:
+		return CommandReply{Status: http.StatusNotFound, Error: &Error{Code: "UNKNOWN_COMMAND", Message: "command must be pr.create or pr.merge"}}
+	}
+	return CommandReply{Status: status, PR: review, Error: wErr}
+}
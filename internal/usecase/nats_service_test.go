@@ -0,0 +1,123 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+const natsPrefix = "pr-service"
+
+func connectNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	srv := test.RunRandClientPortServer()
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestNATSSinkSend(t *testing.T) {
+	nc := connectNATS(t)
+	sub, err := nc.SubscribeSync(natsPrefix + ".events.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := models.Event{ID: 42, Type: models.EventPRMerged, PullRequestID: "pr-1", TeamName: "backend"}
+	if err := NewNATSSink(nc, natsPrefix).Send(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "pr-service.events.pr.merged" {
+		t.Fatalf("subject = %s", msg.Subject)
+	}
+	if id := msg.Header.Get(nats.MsgIdHdr); id != "42" {
+		t.Fatalf("%s = %q, want 42", nats.MsgIdHdr, id)
+	}
+	var got models.Event
+	if err := json.Unmarshal(msg.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != event.ID || got.Type != event.Type || got.PullRequestID != event.PullRequestID {
+		t.Fatalf("event = %+v", got)
+	}
+}
+
+func TestCommandConsumer(t *testing.T) {
+	nc := connectNATS(t)
+	repo := newMemRepo()
+	l := logger.New()
+	prs := NewPRService(repo, l, nopMetrics{})
+	// две реплики в одной очереди: каждую команду выполняет одна
+	for range 2 {
+		if _, err := NewCommandConsumer(nc, prs, natsPrefix, l).Subscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := func(command, body string) CommandReply {
+		t.Helper()
+		msg, err := nc.Request(natsPrefix+".commands."+command, []byte(body), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply CommandReply
+		if err := json.Unmarshal(msg.Data, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	reply := request("pr.create", `{"pull_request_id": "pr-1", "pull_request_name": "Add cache", "author_id": "u1"}`)
+	if reply.Status != http.StatusCreated || reply.Error != nil || reply.PR == nil || reply.PR.ID != "pr-1" || reply.PR.Status != "OPEN" {
+		t.Fatalf("create reply = %+v", reply)
+	}
+	if len(reply.PR.AssignedReviewers) != 2 {
+		t.Fatalf("reviewers = %v", reply.PR.AssignedReviewers)
+	}
+
+	reply = request("pr.create", `{"pull_request_id": "pr-1", "pull_request_name": "Add cache", "author_id": "u1"}`)
+	if reply.Status != http.StatusConflict || reply.Error == nil || reply.Error.Code != "PR_EXISTS" {
+		t.Fatalf("second create reply = %+v", reply)
+	}
+
+	reply = request("pr.merge", `{"pull_request_id": "pr-1"}`)
+	if reply.Status != http.StatusOK || reply.Error != nil || reply.PR == nil || reply.PR.Status != "MERGED" {
+		t.Fatalf("merge reply = %+v", reply)
+	}
+	if repo.prs["pr-1"].Status != "MERGED" {
+		t.Fatalf("pr = %+v", repo.prs["pr-1"])
+	}
+
+	reply = request("pr.close", `{"pull_request_id": "pr-1"}`)
+	if reply.Status != http.StatusNotFound || reply.Error == nil || reply.Error.Code != "UNKNOWN_COMMAND" {
+		t.Fatalf("unknown command reply = %+v", reply)
+	}
+
+	reply = request("pr.create", `{"pull_request_id": `)
+	if reply.Status != http.StatusBadRequest || reply.Error == nil || reply.Error.Code != "INVALID_JSON" {
+		t.Fatalf("invalid json reply = %+v", reply)
+	}
+
+	want := []string{models.EventPRCreated, models.EventReviewerAssigned, models.EventReviewerAssigned, models.EventPRMerged}
+	if got := repo.eventTypes(); !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,333 @@
+package usecase
+
+import (
+	"context"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var (
+	notificationChannels = []string{"chat", "email"}
+	// notificationEvents события, о которых уведомляют notifier'ы
+	notificationEvents = []string{models.EventReviewerAssigned, models.EventReviewerReplaced, models.EventPRMerged,
+		models.EventReviewReminder, models.EventReviewEscalated}
+	digestFrequencies = []string{"daily", "weekly", "off"}
+)
+
+// NotificationService настройки уведомлений: webhook'и чатов и настройки пользователей
+type NotificationService struct {
+	repo repository.Repository
+	l    logger.Logger
+}
+
+func NewNotificationService(repo repository.Repository, l logger.Logger) *NotificationService {
+	return &NotificationService{repo: repo, l: l}
+}
+
+func (s *NotificationService) SetChatWebhook(hook models.ChatWebhook) (*models.ChatWebhook, int, *Error) {
+	if (hook.TeamName == nil || *hook.TeamName == "") == (hook.UserID == nil || *hook.UserID == "") {
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_TARGET", Message: "exactly one of team_name or user_id is required"}
+	}
+	if !validHTTPURL(hook.URL) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_URL", Message: "url must be absolute http(s) url"}
+	}
+
+	var notFound bool
+	var err error
+	if hook.UserID != nil {
+		_, notFound, err = s.repo.GetUserByID(*hook.UserID)
+	} else {
+		_, notFound, err = s.repo.GetTeam(*hook.TeamName)
+	}
+	if notFound {
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	hook.ID = 0
+	if err := s.repo.SetChatWebhook(&hook); err != nil {
+		s.l.Errorf("Error in bd (set chat webhook). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return &hook, http.StatusOK, nil
+}
+
+func (s *NotificationService) DeleteChatWebhook(hook models.ChatWebhook) (int, *Error) {
+	var teamName, userID string
+	if hook.TeamName != nil {
+		teamName = *hook.TeamName
+	}
+	if hook.UserID != nil {
+		userID = *hook.UserID
+	}
+	if (teamName == "") == (userID == "") {
+		return http.StatusBadRequest, &Error{Code: "INVALID_TARGET", Message: "exactly one of team_name or user_id is required"}
+	}
+
+	notFound, err := s.repo.DeleteChatWebhook(teamName, userID)
+	if notFound {
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (delete chat webhook). Err %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *NotificationService) ListChatWebhooks() ([]models.ChatWebhook, int, *Error) {
+	hooks, err := s.repo.ListChatWebhooks()
+	if err != nil {
+		s.l.Errorf("Error in bd (list chat webhooks). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if hooks == nil {
+		hooks = []models.ChatWebhook{}
+	}
+	return hooks, http.StatusOK, nil
+}
+
+// GetPreferences если пользователь ничего не настраивал - настройки по умолчанию
+func (s *NotificationService) GetPreferences(userID string) (*models.NotificationPreferences, int, *Error) {
+	_, notFound, err := s.repo.GetUserByID(userID)
+	if notFound {
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	prefs, notFound, err := s.repo.GetNotificationPreferences(userID)
+	if notFound {
+		return defaultPreferences(userID), http.StatusOK, nil
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (get preferences). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return prefs, http.StatusOK, nil
+}
+
+// SetPreferences заменяет настройки целиком, не переданные поля - по умолчанию.
+// channels ["none"] или [] выключает уведомления
+func (s *NotificationService) SetPreferences(prefs models.NotificationPreferences) (*models.NotificationPreferences, int, *Error) {
+	switch {
+	case prefs.Channels == nil:
+		prefs.Channels = slices.Clone(notificationChannels)
+	case len(prefs.Channels) == 1 && prefs.Channels[0] == "none":
+		prefs.Channels = []string{}
+	}
+	for _, channel := range prefs.Channels {
+		if !slices.Contains(notificationChannels, channel) {
+			return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_CHANNEL", Message: "channels must be chat, email or none"}
+		}
+	}
+	if prefs.EventTypes == nil {
+		prefs.EventTypes = slices.Clone(notificationEvents)
+	}
+	for _, eventType := range prefs.EventTypes {
+		if !slices.Contains(notificationEvents, eventType) {
+			return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_EVENT_TYPE",
+				Message: "event_types must be pr.reviewer_assigned, pr.reviewer_replaced, pr.merged, pr.review_reminder or pr.review_escalated"}
+		}
+	}
+	slices.Sort(prefs.Channels)
+	prefs.Channels = slices.Compact(prefs.Channels)
+	slices.Sort(prefs.EventTypes)
+	prefs.EventTypes = slices.Compact(prefs.EventTypes)
+	if prefs.DigestFrequency == "" {
+		prefs.DigestFrequency = "daily"
+	}
+	if !slices.Contains(digestFrequencies, prefs.DigestFrequency) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_DIGEST_FREQUENCY", Message: "digest_frequency must be daily, weekly or off"}
+	}
+	if prefs.Timezone == "" {
+		prefs.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_TIMEZONE", Message: "timezone must be IANA name, e.g. Europe/Moscow"}
+	}
+	if (prefs.QuietStart == "") != (prefs.QuietEnd == "") || !validClock(prefs.QuietStart) || !validClock(prefs.QuietEnd) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_QUIET_HOURS",
+			Message: "quiet_hours_start and quiet_hours_end must be both set as HH:MM or both empty"}
+	}
+
+	_, notFound, err := s.repo.GetUserByID(prefs.UserID)
+	if notFound {
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	prefs.UpdatedAt = time.Now()
+	if err := s.repo.SaveNotificationPreferences(&prefs); err != nil {
+		s.l.Errorf("Error in bd (save preferences). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return &prefs, http.StatusOK, nil
+}
+
+func defaultPreferences(userID string) *models.NotificationPreferences {
+	return &models.NotificationPreferences{
+		UserID:          userID,
+		Channels:        slices.Clone(notificationChannels),
+		EventTypes:      slices.Clone(notificationEvents),
+		DigestFrequency: "daily",
+		Timezone:        "UTC",
+	}
+}
+
+// userPreferences настройки пользователя, если он ничего не настраивал - по умолчанию
+func userPreferences(repo repository.Repository, userID string) (*models.NotificationPreferences, error) {
+	prefs, notFound, err := repo.GetNotificationPreferences(userID)
+	if notFound {
+		return defaultPreferences(userID), nil
+	}
+	return prefs, err
+}
+
+// wantsNotification пользователь включил канал и тип события
+func wantsNotification(prefs *models.NotificationPreferences, channel, eventType string) bool {
+	return slices.Contains(prefs.Channels, channel) && slices.Contains(prefs.EventTypes, eventType)
+}
+
+// wantsDigest дайджест за day: email включен, weekly - только по понедельникам
+func wantsDigest(prefs *models.NotificationPreferences, day time.Time) bool {
+	if !slices.Contains(prefs.Channels, "email") {
+		return false
+	}
+	switch prefs.DigestFrequency {
+	case "daily":
+		return true
+	case "weekly":
+		return day.Weekday() == time.Monday
+	}
+	return false
+}
+
+// stillWanted настройки на момент отправки. Дайджест - пока включены email и дайджест
+func stillWanted(prefs *models.NotificationPreferences, channel, eventType string) bool {
+	if eventType == "digest" {
+		return slices.Contains(prefs.Channels, channel) && prefs.DigestFrequency != "off"
+	}
+	return wantsNotification(prefs, channel, eventType)
+}
+
+func validClock(v string) bool {
+	if v == "" {
+		return true
+	}
+	_, err := time.Parse("15:04", v)
+	return err == nil
+}
+
+// quietHoursEnd конец тихих часов, если now в них попадает, иначе now
+func quietHoursEnd(prefs *models.NotificationPreferences, now time.Time) time.Time {
+	if prefs == nil || prefs.QuietStart == "" || prefs.QuietEnd == "" {
+		return now
+	}
+	start, err := time.Parse("15:04", prefs.QuietStart)
+	if err != nil {
+		return now
+	}
+	end, err := time.Parse("15:04", prefs.QuietEnd)
+	if err != nil {
+		return now
+	}
+	loc, err := time.LoadLocation(prefs.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := now.In(loc)
+	cur := local.Hour()*60 + local.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+
+	var quiet bool
+	switch {
+	case from < to:
+		quiet = cur >= from && cur < to
+	case from > to: // через полночь, например 22:00-08:00
+		quiet = cur >= from || cur < to
+	}
+	if !quiet {
+		return now
+	}
+
+	day := local
+	if from > to && cur >= from {
+		day = local.AddDate(0, 0, 1)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+}
+
+// dispatchNotifications отправляет уведомления канала, которым пора уходить. Повторы и лимит попыток как у вебхуков.
+// Настройки получателя проверяются еще раз: пока уведомление ждало, он мог отключить канал или тип события (SKIPPED)
+// или включить тихие часы
+func dispatchNotifications(ctx context.Context, repo repository.Repository, l logger.Logger, channel string,
+	send func(ctx context.Context, n *models.Notification) error) error {
+	for ctx.Err() == nil {
+		batch, err := repo.ClaimNotifications(channel, deliveryBatchSize, deliveryLease)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		prefs := make(map[string]*models.NotificationPreferences)
+		for i := range batch {
+			n := &batch[i]
+			p, ok := prefs[n.UserID]
+			if !ok {
+				if p, err = userPreferences(repo, n.UserID); err != nil {
+					return err
+				}
+				prefs[n.UserID] = p
+			}
+
+			now := time.Now()
+			if !stillWanted(p, channel, n.EventType) {
+				n.Status = "SKIPPED"
+				if err := repo.UpdateNotification(n); err != nil {
+					return err
+				}
+				continue
+			}
+			if next := quietHoursEnd(p, now); next.After(now) {
+				n.NextAttemptAt = next
+				if err := repo.UpdateNotification(n); err != nil {
+					return err
+				}
+				continue
+			}
+
+			n.Attempts++
+			if err := send(ctx, n); err != nil {
+				n.LastError = err.Error()
+				if n.Attempts >= deliveryMaxAttempts {
+					n.Status = "FAILED"
+					l.Warnf("Notification %d (%s) to user %s failed after %d attempts: %v", n.ID, channel, n.UserID, n.Attempts, err)
+				} else {
+					n.NextAttemptAt = now.Add(deliveryDelay(n.Attempts))
+				}
+			} else {
+				n.Status, n.LastError, n.SentAt = "SENT", "", &now
+			}
+			if err := repo.UpdateNotification(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+func TestDispatchNotificationsRechecksPreferences(t *testing.T) {
+	repo := newMemRepo()
+	past := time.Now().Add(-time.Minute)
+	queued := func(userID, eventType string, eventID int64) models.Notification {
+		return models.Notification{Channel: "chat", UserID: userID, Target: "https://hooks.example.com/" + userID,
+			EventID: eventID, EventType: eventType, Body: "hi", Status: "PENDING", NextAttemptAt: past}
+	}
+	if err := repo.CreateNotifications([]models.Notification{
+		queued("u2", models.EventReviewerAssigned, 1), // настройки по умолчанию
+		queued("u3", models.EventReviewerAssigned, 1), // отключил чат
+		queued("u4", models.EventPRMerged, 2),         // отключил pr.merged
+		queued("u4", models.EventReviewerAssigned, 3),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo.prefs["u3"] = models.NotificationPreferences{UserID: "u3", Channels: []string{"email"},
+		EventTypes: notificationEvents, DigestFrequency: "daily", Timezone: "UTC"}
+	repo.prefs["u4"] = models.NotificationPreferences{UserID: "u4", Channels: notificationChannels,
+		EventTypes: []string{models.EventReviewerAssigned}, DigestFrequency: "daily", Timezone: "UTC"}
+
+	var sent []string
+	err := dispatchNotifications(context.Background(), repo, logger.New(), "chat", func(_ context.Context, n *models.Notification) error {
+		sent = append(sent, n.UserID+" "+n.EventType)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"u2 " + models.EventReviewerAssigned: "SENT",
+		"u3 " + models.EventReviewerAssigned: "SKIPPED",
+		"u4 " + models.EventPRMerged:         "SKIPPED",
+		"u4 " + models.EventReviewerAssigned: "SENT",
+	}
+	for _, n := range repo.notifications {
+		key := n.UserID + " " + n.EventType
+		if n.Status != want[key] {
+			t.Errorf("%s: status %s, want %s", key, n.Status, want[key])
+		}
+		if n.Status == "SKIPPED" && n.Attempts != 0 {
+			t.Errorf("%s: skipped after %d attempts", key, n.Attempts)
+		}
+	}
+	if len(sent) != 2 {
+		t.Fatalf("sent = %v", sent)
+	}
+}
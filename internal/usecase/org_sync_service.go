@@ -0,0 +1,310 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type OrgSyncService struct {
+	repo repository.Repository
+	l    logger.Logger
+	m    DomainMetrics
+}
+
+func NewOrgSyncService(repo repository.Repository, l logger.Logger, m DomainMetrics) *OrgSyncService {
+	return &OrgSyncService{repo: repo, l: l, m: m}
+}
+
+// ParseOrgChart разбирает описание оргструктуры в формате yaml или csv.
+// CSV: заголовок team_name,user_id,username[,is_active][,account_type]
+func ParseOrgChart(data []byte, format string) (*models.OrgChart, error) {
+	var chart models.OrgChart
+	switch strings.ToLower(format) {
+	case "", "yaml", "yml":
+		if err := yaml.Unmarshal(data, &chart); err != nil {
+			return nil, err
+		}
+	case "csv":
+		c, err := parseOrgCSV(data)
+		if err != nil {
+			return nil, err
+		}
+		chart = *c
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return &chart, validateOrgChart(&chart)
+}
+
+func parseOrgCSV(data []byte) (*models.OrgChart, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"team_name", "user_id", "username"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var chart models.OrgChart
+	teams := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		member := models.OrgMember{
+			UserID:   record[columns["user_id"]],
+			Username: record[columns["username"]],
+		}
+		if i, ok := columns["is_active"]; ok && record[i] != "" {
+			active, err := strconv.ParseBool(record[i])
+			if err != nil {
+				return nil, fmt.Errorf("user %s: invalid is_active %q", member.UserID, record[i])
+			}
+			member.IsActive = &active
+		}
+		if i, ok := columns["account_type"]; ok {
+			member.AccountType = record[i]
+		}
+
+		teamName := record[columns["team_name"]]
+		idx, ok := teams[teamName]
+		if !ok {
+			idx = len(chart.Teams)
+			teams[teamName] = idx
+			chart.Teams = append(chart.Teams, models.OrgTeam{Name: teamName})
+		}
+		chart.Teams[idx].Members = append(chart.Teams[idx].Members, member)
+	}
+	return &chart, nil
+}
+
+func validateOrgChart(chart *models.OrgChart) error {
+	// пустой файл не должен деактивировать всю организацию
+	if len(chart.Teams) == 0 {
+		return errors.New("org chart has no teams")
+	}
+	teams := make(map[string]bool)
+	users := make(map[string]bool)
+	for i := range chart.Teams {
+		team := &chart.Teams[i]
+		if len(strings.TrimSpace(team.Name)) == 0 {
+			return errors.New("empty team name")
+		}
+		if teams[team.Name] {
+			return fmt.Errorf("duplicate team %s", team.Name)
+		}
+		teams[team.Name] = true
+
+		for j := range team.Members {
+			member := &team.Members[j]
+			if len(strings.TrimSpace(member.UserID)) == 0 {
+				return fmt.Errorf("team %s: empty user_id", team.Name)
+			}
+			if users[member.UserID] {
+				return fmt.Errorf("duplicate user_id %s", member.UserID)
+			}
+			users[member.UserID] = true
+
+			if member.AccountType == "" {
+				member.AccountType = "HUMAN"
+			}
+			if !validAccountType(member.AccountType) {
+				return fmt.Errorf("user %s: invalid account_type %s", member.UserID, member.AccountType)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *OrgSyncService) Import(data []byte, format string, dryRun, prune bool) (*models.SyncPlan, int, *Error) {
+	chart, err := ParseOrgChart(data, format)
+	if err != nil {
+		s.l.Warnf("invalid org chart. Err %v", err)
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_ORG_CHART", Message: err.Error()}
+	}
+	return s.Sync(chart, dryRun, prune)
+}
+
+// Sync сравнивает оргструктуру с базой и, если dryRun=false, применяет изменения в одной транзакции.
+// Активные пользователи команд из описания, которых в нем нет, деактивируются с переназначением их ревью.
+// prune - деактивировать всех отсутствующих в описании, в т.ч. из команд, которых в нем нет
+func (s *OrgSyncService) Sync(chart *models.OrgChart, dryRun, prune bool) (*models.SyncPlan, int, *Error) {
+	plan, err := s.plan(chart, prune)
+	if err != nil {
+		s.l.Errorf("Error in bd (sync plan). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	plan.DryRun, plan.Prune = dryRun, prune
+	if dryRun {
+		return plan, http.StatusOK, nil
+	}
+
+	var reassigned []models.Reassignment
+	if err := s.repo.Transaction(func(tx repository.Repository) error {
+		var err error
+		if reassigned, err = applySyncPlan(tx, plan); err != nil {
+			return err
+		}
+		events, err := reassignmentEvents(tx, reassigned)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(events)
+	}); err != nil {
+		s.l.Errorf("Error in bd (apply sync plan). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	// метрику считаю после коммита: при откате переназначений не было
+	countNoCandidate(s.m, "sync", reassigned)
+	return plan, http.StatusOK, nil
+}
+
+func (s *OrgSyncService) plan(chart *models.OrgChart, prune bool) (*models.SyncPlan, error) {
+	teamNames, err := s.repo.GetTeamNames()
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.repo.ListUsers(models.UserFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	existingTeams := make(map[string]bool, len(teamNames))
+	for _, name := range teamNames {
+		existingTeams[name] = true
+	}
+	existingUsers := make(map[string]models.User, len(users))
+	for _, u := range users {
+		existingUsers[u.ID] = u
+	}
+
+	plan := models.SyncPlan{
+		CreateTeams:     make([]string, 0),
+		CreateUsers:     make([]models.User, 0),
+		UpdateUsers:     make([]models.UpdateUserInfo, 0),
+		ActivateUsers:   make([]string, 0),
+		DeactivateUsers: make([]string, 0),
+	}
+	listed := make(map[string]bool)
+	chartTeams := make(map[string]bool, len(chart.Teams))
+
+	for _, team := range chart.Teams {
+		chartTeams[team.Name] = true
+		if !existingTeams[team.Name] {
+			plan.CreateTeams = append(plan.CreateTeams, team.Name)
+		}
+
+		for _, member := range team.Members {
+			listed[member.UserID] = true
+			active := member.IsActive == nil || *member.IsActive
+
+			current, ok := existingUsers[member.UserID]
+			if !ok {
+				plan.CreateUsers = append(plan.CreateUsers, models.User{
+					ID:          member.UserID,
+					Username:    member.Username,
+					TeamName:    team.Name,
+					IsActive:    active,
+					AccountType: member.AccountType,
+				})
+				continue
+			}
+
+			update := models.UpdateUserInfo{ID: member.UserID}
+			if member.Username != "" && member.Username != current.Username {
+				update.Username = member.Username
+			}
+			if team.Name != current.TeamName {
+				update.TeamName = team.Name
+			}
+			if member.AccountType != current.AccountType {
+				update.AccountType = member.AccountType
+			}
+			if update.Username != "" || update.TeamName != "" || update.AccountType != "" {
+				plan.UpdateUsers = append(plan.UpdateUsers, update)
+			}
+
+			switch {
+			case active && !current.IsActive:
+				plan.ActivateUsers = append(plan.ActivateUsers, member.UserID)
+			case !active && current.IsActive:
+				plan.DeactivateUsers = append(plan.DeactivateUsers, member.UserID)
+			}
+		}
+	}
+
+	for _, u := range users {
+		if !listed[u.ID] && u.IsActive && (prune || chartTeams[u.TeamName]) {
+			plan.DeactivateUsers = append(plan.DeactivateUsers, u.ID)
+		}
+	}
+
+	return &plan, nil
+}
+
+// applySyncPlan порядок важен: сначала команды и переезды, потом деактивация,
+// чтобы ревью переназначались уже внутри новых команд. Возвращает переназначенные ревью
+func applySyncPlan(tx repository.Repository, plan *models.SyncPlan) ([]models.Reassignment, error) {
+	for _, team := range plan.CreateTeams {
+		if err := tx.CreateTeam(team); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range plan.CreateUsers {
+		if err := tx.CreateUser(&plan.CreateUsers[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []models.Reassignment
+	for _, update := range plan.UpdateUsers {
+		_, _, reassigned, err := updateUserInfo(tx, update)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, reassigned...)
+	}
+
+	for _, id := range plan.ActivateUsers {
+		if _, err := tx.UpdateUser(&models.User{ID: id, IsActive: true}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range plan.DeactivateUsers {
+		reassigned, err := reassignOpenReviews(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, reassigned...)
+		if _, err := tx.UpdateUser(&models.User{ID: id, IsActive: false}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -5,39 +5,51 @@ import (
 	"github.com/ashurov-imomali/pr-service/internal/repository"
 	"github.com/ashurov-imomali/pr-service/pkg/logger"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
 
+// maxReviewers столько ревьюверов назначается на PR, и при случайном выборе, и при заданных снаружи
+const maxReviewers = 2
+
 type PRService struct {
 	repo repository.Repository
 	l    logger.Logger
+	m    DomainMetrics
 }
 
-func NewPRService(repo repository.Repository, l logger.Logger) *PRService {
-	return &PRService{repo: repo, l: l}
+func NewPRService(repo repository.Repository, l logger.Logger, m DomainMetrics) *PRService {
+	return &PRService{repo: repo, l: l, m: m}
 }
 
 func (s *PRService) CreatePullRequest(pr models.PullRequest) (*models.Review, int, *Error) {
+	return s.CreatePullRequestWithReviewers(pr, nil)
+}
+
+// CreatePullRequestWithReviewers ревьюверы заданы снаружи (например, в GitLab). Неподходящие отбрасываются,
+// если не осталось ни одного - назначаются случайные из команды автора
+func (s *PRService) CreatePullRequestWithReviewers(pr models.PullRequest, reviewerIDs []string) (*models.Review, int, *Error) {
 	if len(strings.TrimSpace(pr.Name)) == 0 {
 		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_PULL_REQUEST_NAME"}
 	}
 
-	user, notFound, err := s.repo.GetUserByID(pr.AuthorID) //не активный пользователь
-	if notFound {
-		s.l.Warnf("user not found. ID: %s", pr.AuthorID)
-		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	user, status, wErr := s.getAuthor(pr) //не активный пользователь
+	if wErr != nil {
+		return nil, status, wErr
 	}
+	pr.AuthorID = user.ID
 
+	randUsers, err := s.eligibleReviewers(reviewerIDs, pr.AuthorID)
 	if err != nil {
-		s.l.Errorf("Error in DB (get user). Error %v", err)
+		s.l.Errorf("Error in BD (get reviewers). err %v", err)
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
-
-	randUsers, _, err := s.repo.GetUsersIDByTeamName(user.TeamName, pr.AuthorID)
-	if err != nil {
-		s.l.Errorf("Error in BD (get user by teamnam). err %v", err)
-		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	if len(randUsers) == 0 {
+		if randUsers, _, err = s.repo.GetUsersIDByTeamName(user.TeamName, pr.AuthorID); err != nil {
+			s.l.Errorf("Error in BD (get user by teamnam). err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
 	}
 
 	reviewers := make([]models.PrReviewer, len(randUsers))
@@ -46,16 +58,36 @@ func (s *PRService) CreatePullRequest(pr models.PullRequest) (*models.Review, in
 		reviewers[i].ReviewerID = randUsers[i]
 	}
 
-	pr.Status = "OPEN"
-	if err := s.repo.CreatePullRequest(&pr, reviewers); err != nil {
-		s.l.Errorf("Error in BD (create PR). Err %v", err)
+	// время создания и merge ставит сервис: клиент не должен сдвигать lead time и перцентили
+	pr.Status, pr.CreatedAt, pr.MergedAt = "OPEN", time.Time{}, nil
+	var createErr error
+	err = s.repo.Transaction(func(tx repository.Repository) error {
+		if createErr = tx.CreatePullRequest(&pr, reviewers); createErr != nil {
+			return createErr
+		}
+		review := models.Review{PullRequest: pr, AssignedReviewers: randUsers}
+		return tx.AddOutboxEvents(reviewEvents(models.EventPRCreated, review, user.TeamName))
+	})
+	if createErr != nil {
+		s.l.Errorf("Error in BD (create PR). Err %v", createErr)
 		return nil, http.StatusConflict, &Error{Code: "PR_EXISTS", Message: "PR id already exists"}
 	}
+	if err != nil {
+		s.l.Errorf("Error in BD (add outbox events). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
 
 	return &models.Review{PullRequest: pr, AssignedReviewers: randUsers}, http.StatusCreated, nil
 }
 
-func (s *PRService) MergePullRequest(pullRequestID string) (*models.Review, int, *Error) {
+func (s *PRService) MergePullRequest(request models.PullRequest) (*models.Review, int, *Error) {
+	pullRequestID := request.ID
+	if request.AuthorID != "" || request.AuthorIdentity != nil {
+		if status, wErr := s.checkAuthor(request); wErr != nil {
+			return nil, status, wErr
+		}
+	}
+
 	now := time.Now()
 	pr := models.PullRequest{
 		ID:       pullRequestID,
@@ -63,23 +95,45 @@ func (s *PRService) MergePullRequest(pullRequestID string) (*models.Review, int,
 		MergedAt: &now,
 	}
 
-	notFound, err := s.repo.UpdatePullRequest(&pr)
+	var notFound bool
+	var review models.Review
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		notOpen, err := tx.MergePullRequest(&pr)
+		if err != nil {
+			return err
+		}
+		if notOpen {
+			// повторный merge: отдаю сохраненное состояние, merged_at и событие не трогаю
+			var existing *models.PullRequest
+			if existing, notFound, err = tx.GetPullRequestByID(pr.ID); notFound || err != nil {
+				return err
+			}
+			pr = *existing
+		}
+		ids, err := tx.GetUsersIDByReviewID(pr.ID)
+		if err != nil {
+			return err
+		}
+		review = models.Review{PullRequest: pr, AssignedReviewers: ids}
+		if notOpen {
+			return nil
+		}
+		teamName, err := tx.GetPullRequestTeam(pr.ID)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(reviewEvents(models.EventPRMerged, review, teamName))
+	})
 	if notFound {
 		s.l.Warnf("PullRequest not found. id: %s", pullRequestID)
 		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
 	}
 	if err != nil {
-		s.l.Errorf("Err in bd (update PR). Err: %v", err)
-		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
-	}
-
-	ids, err := s.repo.GetUsersIDByReviewID(pr.ID)
-	if err != nil {
-		s.l.Errorf("Err in bd (get reviewers). Err %v", err)
+		s.l.Errorf("Err in bd (merge PR). Err: %v", err)
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
 
-	return &models.Review{PullRequest: pr, AssignedReviewers: ids}, http.StatusOK, nil
+	return &review, http.StatusOK, nil
 }
 
 func (s *PRService) UpdateReviewer(review models.UpdateReviewer) (*models.UpdatedPR, int, *Error) {
@@ -90,6 +144,7 @@ func (s *PRService) UpdateReviewer(review models.UpdateReviewer) (*models.Update
 
 	newReviewer, notFound, err := s.repo.GetRandomUser(review.OldReviewerID, review.PullRequestID)
 	if notFound {
+		s.m.NoCandidate("reassign")
 		s.l.Warnf("No candidate %+v", review)
 		return nil, http.StatusConflict, &Error{Code: "NO_CANDIDATE", Message: "no active replacement candidate in team"}
 	}
@@ -99,7 +154,20 @@ func (s *PRService) UpdateReviewer(review models.UpdateReviewer) (*models.Update
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
 
-	if err := s.repo.UpdateReviewer(review.PullRequestID, review.OldReviewerID, newReviewer); err != nil {
+	if err := s.repo.Transaction(func(tx repository.Repository) error {
+		if err := tx.UpdateReviewer(review.PullRequestID, review.OldReviewerID, newReviewer); err != nil {
+			return err
+		}
+		events, err := reassignmentEvents(tx, []models.Reassignment{{
+			PullRequestID: pr.ID,
+			OldReviewerID: review.OldReviewerID,
+			NewReviewerID: newReviewer,
+		}})
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(events)
+	}); err != nil {
 		s.l.Errorf("Error in bd (update review). Err %v", err)
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
@@ -115,6 +183,145 @@ func (s *PRService) UpdateReviewer(review models.UpdateReviewer) (*models.Update
 		ReplacedBy:        newReviewer}, http.StatusOK, nil
 }
 
+// SetReviewers приводит список ревьюверов открытого PR к заданному. Неподходящие отбрасываются,
+// если не осталось ни одного - ревьюверы не меняются
+func (s *PRService) SetReviewers(prID string, reviewerIDs []string) (*models.Review, int, *Error) {
+	pr, notFound, err := s.repo.GetPullRequestByID(prID)
+	switch {
+	case notFound:
+		s.l.Warnf("PullRequest not found. id: %s", prID)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	case err != nil:
+		s.l.Errorf("Error in bd (get pr by id). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	case pr.Status == "MERGED":
+		return nil, http.StatusConflict, &Error{Code: "PR_MERGED", Message: "cannot reassign on merged PR"}
+	}
+
+	wanted, err := s.eligibleReviewers(reviewerIDs, pr.AuthorID)
+	if err != nil {
+		s.l.Errorf("Error in bd (get reviewers). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if len(wanted) == 0 {
+		current, err := s.repo.GetUsersIDByPRID(prID)
+		if err != nil {
+			s.l.Errorf("Error in bd (get reviewers). Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+		return &models.Review{PullRequest: *pr, AssignedReviewers: current}, http.StatusOK, nil
+	}
+
+	if err := s.repo.Transaction(func(tx repository.Repository) error {
+		current, err := tx.GetUsersIDByPRID(prID)
+		if err != nil {
+			return err
+		}
+		var removed []models.Reassignment
+		var added []string
+		for _, id := range current {
+			if !slices.Contains(wanted, id) {
+				if err := tx.DeleteReviewer(id, prID); err != nil {
+					return err
+				}
+				removed = append(removed, models.Reassignment{PullRequestID: prID, OldReviewerID: id})
+			}
+		}
+		for _, id := range wanted {
+			if !slices.Contains(current, id) {
+				if err := tx.AddReviewer(prID, id); err != nil {
+					return err
+				}
+				added = append(added, id)
+			}
+		}
+
+		events, err := reassignmentEvents(tx, removed)
+		if err != nil {
+			return err
+		}
+		teamName, err := tx.GetPullRequestTeam(prID)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(append(events, assignmentEvents(prID, teamName, added)...))
+	}); err != nil {
+		s.l.Errorf("Error in bd (set reviewers). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	return &models.Review{PullRequest: *pr, AssignedReviewers: wanted}, http.StatusOK, nil
+}
+
+// eligibleReviewers оставляет активных HUMAN пользователей, кроме автора, без повторов, не больше maxReviewers
+func (s *PRService) eligibleReviewers(ids []string, authorID string) ([]string, error) {
+	result := make([]string, 0, maxReviewers)
+	for _, id := range ids {
+		if len(result) == maxReviewers {
+			break
+		}
+		if id == authorID || slices.Contains(result, id) {
+			continue
+		}
+		user, notFound, err := s.repo.GetUserByID(id)
+		if notFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.IsActive && user.AccountType == "HUMAN" {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// getAuthor автор задается либо author_id, либо внешней идентичностью (github/gitlab/email)
+func (s *PRService) getAuthor(pr models.PullRequest) (*models.User, int, *Error) {
+	var (
+		user     *models.User
+		notFound bool
+		err      error
+	)
+	if pr.AuthorID == "" && pr.AuthorIdentity != nil {
+		user, notFound, err = resolveIdentity(s.repo, *pr.AuthorIdentity)
+	} else {
+		user, notFound, err = s.repo.GetUserByID(pr.AuthorID)
+	}
+
+	if notFound {
+		s.l.Warnf("user not found. ID: %s identity: %+v", pr.AuthorID, pr.AuthorIdentity)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (get user). Error %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return user, http.StatusOK, nil
+}
+
+func (s *PRService) checkAuthor(request models.PullRequest) (int, *Error) {
+	author, status, wErr := s.getAuthor(request)
+	if wErr != nil {
+		return status, wErr
+	}
+
+	pr, notFound, err := s.repo.GetPullRequestByID(request.ID)
+	if notFound {
+		s.l.Warnf("PullRequest not found. id: %s", request.ID)
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (get pr by id). Err %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if pr.AuthorID != author.ID {
+		return http.StatusConflict, &Error{Code: "AUTHOR_MISMATCH", Message: "author does not match pull request"}
+	}
+	return http.StatusOK, nil
+}
+
 func (s *PRService) validateReassign(review models.UpdateReviewer) (*models.PullRequest, int, *Error) {
 	pr, notFound, err := s.repo.GetPullRequestByID(review.PullRequestID)
 	switch {
@@ -141,3 +348,30 @@ func (s *PRService) validateReassign(review models.UpdateReviewer) (*models.Pull
 
 	return pr, http.StatusOK, nil
 }
+
+func (s *PRService) SubmitReview(review models.ReviewVerdict) (*models.PrReviewer, int, *Error) {
+	switch review.Verdict {
+	case "APPROVED", "CHANGES_REQUESTED", "COMMENTED":
+	default:
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_VERDICT",
+			Message: "verdict must be APPROVED, CHANGES_REQUESTED or COMMENTED"}
+	}
+
+	_, status, wErr := s.validateReassign(models.UpdateReviewer{
+		PullRequestID: review.PullRequestID,
+		OldReviewerID: review.ReviewerID,
+	})
+	if wErr != nil {
+		return nil, status, wErr
+	}
+
+	result, notFound, err := s.repo.SetReviewVerdict(review.PullRequestID, review.ReviewerID, review.Verdict)
+	if notFound {
+		return nil, http.StatusConflict, &Error{Code: "NOT_ASSIGNED", Message: "reviewer is not assigned to this PR"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (set verdict). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return result, http.StatusOK, nil
+}
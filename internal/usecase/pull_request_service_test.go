@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+func TestMergePullRequestIsIdempotent(t *testing.T) {
+	repo := newMemRepo()
+	prs := NewPRService(repo, logger.New(), nopMetrics{})
+	if _, _, wErr := prs.CreatePullRequest(models.PullRequest{ID: "pr-1", Name: "Add cache", AuthorID: "u1"}); wErr != nil {
+		t.Fatal(wErr)
+	}
+	repo.events = nil
+
+	first, status, wErr := prs.MergePullRequest(models.PullRequest{ID: "pr-1"})
+	if wErr != nil || status != http.StatusOK || first.Status != "MERGED" || first.MergedAt == nil {
+		t.Fatalf("merge = %+v, %d, %v", first, status, wErr)
+	}
+	second, status, wErr := prs.MergePullRequest(models.PullRequest{ID: "pr-1"})
+	if wErr != nil || status != http.StatusOK || second.Status != "MERGED" {
+		t.Fatalf("repeated merge = %+v, %d, %v", second, status, wErr)
+	}
+	if !second.MergedAt.Equal(*first.MergedAt) || !repo.prs["pr-1"].MergedAt.Equal(*first.MergedAt) {
+		t.Fatalf("merged_at moved: %v -> %v", first.MergedAt, second.MergedAt)
+	}
+	if !slices.Equal(second.AssignedReviewers, first.AssignedReviewers) || second.Name != "Add cache" {
+		t.Fatalf("repeated merge returned %+v, want %+v", second, first)
+	}
+	if events := repo.eventTypes(); !slices.Equal(events, []string{models.EventPRMerged}) {
+		t.Fatalf("events = %v", events)
+	}
+
+	if _, status, _ := prs.MergePullRequest(models.PullRequest{ID: "pr-2"}); status != http.StatusNotFound {
+		t.Fatalf("unknown PR status = %d", status)
+	}
+}
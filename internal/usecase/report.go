@@ -0,0 +1,250 @@
+package usecase
+
+import (
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// reportPeriod период отчета по умолчанию - неделя до to
+	reportPeriod = 7 * 24 * time.Hour
+	// reportStaleAfter открытый PR старше этого считается зависшим
+	reportStaleAfter = 7 * 24 * time.Hour
+	// reportSlowestLimit сколько самых долгих ревью показывать по команде
+	reportSlowestLimit = 5
+)
+
+// GetReport недельный отчет по командам: смерженные PR, самые долгие ревью, распределение нагрузки и зависшие PR.
+// Если команды не указаны - по всем
+func (s *StatService) GetReport(teamNames []string, filter models.StatFilter) (*models.Report, int, *Error) {
+	filter.Bucket = ""
+	if filter.To == nil {
+		to := time.Now()
+		filter.To = &to
+	}
+	if filter.From == nil {
+		from := filter.To.Add(-reportPeriod)
+		filter.From = &from
+	}
+	if status, wErr := validateStatFilter(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	if len(teamNames) == 0 {
+		names, err := s.repo.GetTeamNames()
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+		teamNames = names
+	}
+
+	// нагрузка из дневных агрегатов - по целым дням периода, остальное по точному периоду
+	loads, status, wErr := s.GetTeamStats(teamNames, dayAligned(filter))
+	if wErr != nil {
+		return nil, status, wErr
+	}
+
+	result := models.Report{
+		From:        *filter.From,
+		To:          *filter.To,
+		GeneratedAt: time.Now(),
+		Teams:       make([]models.TeamReport, 0, len(loads)),
+	}
+	for _, load := range loads {
+		team, err := s.teamReport(load, filter)
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+		result.Teams = append(result.Teams, *team)
+	}
+	return &result, http.StatusOK, nil
+}
+
+// dayAligned расширяет период до целых дней UTC
+func dayAligned(filter models.StatFilter) models.StatFilter {
+	if filter.From != nil {
+		from := filter.From.UTC().Truncate(24 * time.Hour)
+		filter.From = &from
+	}
+	if filter.To != nil {
+		to := filter.To.UTC()
+		if day := to.Truncate(24 * time.Hour); !day.Equal(to) {
+			to = day.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+	return filter
+}
+
+func (s *StatService) teamReport(load models.TeamStat, filter models.StatFilter) (*models.TeamReport, error) {
+	result := models.TeamReport{TeamName: load.TeamName, Load: load}
+
+	var err error
+	if result.MergedPRs, err = s.repo.GetMergedPRs(load.TeamName, filter); err != nil {
+		return nil, err
+	}
+	if result.SlowestReviews, err = s.repo.GetSlowestReviews(load.TeamName, filter, reportSlowestLimit); err != nil {
+		return nil, err
+	}
+
+	stale, err := s.repo.GetStalePRs(load.TeamName, filter.To.Add(-reportStaleAfter))
+	if err != nil {
+		return nil, err
+	}
+	result.StalePRs = make([]models.StalePR, 0, len(stale))
+	for _, pr := range stale {
+		result.StalePRs = append(result.StalePRs, models.StalePR{
+			PullRequest: pr,
+			AgeSeconds:  filter.To.Sub(pr.CreatedAt).Seconds(),
+		})
+	}
+
+	active := make([]float64, 0, len(load.Members))
+	for _, m := range load.Members {
+		if m.IsActive {
+			active = append(active, float64(m.ReviewsCount))
+		}
+	}
+	result.Gini = gini(active)
+	return &result, nil
+}
+
+var reportFuncs = map[string]interface{}{
+	"date": func(t time.Time) string { return t.UTC().Format(time.DateOnly) },
+	"seconds": func(v float64) string {
+		return formatDuration(time.Duration(v) * time.Second)
+	},
+	"leadTime": func(pr models.PullRequest) string {
+		if pr.MergedAt == nil {
+			return "-"
+		}
+		return formatDuration(pr.MergedAt.Sub(pr.CreatedAt))
+	},
+	"percent": func(v float64) string { return fmt.Sprintf("%.0f%%", v*100) },
+	"deref": func(v *string) string {
+		if v == nil {
+			return "-"
+		}
+		return *v
+	},
+	"md": func(v string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(v)
+	},
+}
+
+// formatDuration округляет до минут: 2d 3h, 5h 10m, 15m
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days, hours, minutes := int(d/(24*time.Hour)), int(d/time.Hour)%24, int(d/time.Minute)%60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+var reportMarkdown = template.Must(template.New("report.md").Funcs(reportFuncs).Parse(
+	`# Review report {{date .From}} — {{date .To}}
+{{range .Teams}}
+## {{md .TeamName}}
+
+Members: {{.Load.MembersCount}} (inactive: {{.Load.InactiveMembersCount}}), open PRs: {{.Load.OpenPRs}}, merged PRs: {{.Load.MergedPRs}}
+
+### Merged PRs
+{{if .MergedPRs}}
+| PR | Name | Author | Lead time |
+|----|------|--------|-----------|
+{{range .MergedPRs}}| {{md .ID}} | {{md .Name}} | {{md .AuthorID}} | {{leadTime .}} |
+{{end}}{{else}}
+No merged PRs.
+{{end}}
+### Slowest reviews
+{{if .SlowestReviews}}
+| PR | Reviewer | Assigned | Verdict | Wait |
+|----|----------|----------|---------|------|
+{{range .SlowestReviews}}| {{md .PullRequestID}} | {{md .ReviewerID}} | {{date .AssignedAt}} | {{deref .Verdict}} | {{seconds .WaitSeconds}} |
+{{end}}{{else}}
+No reviews.
+{{end}}
+### Load distribution
+
+Reviews: {{.Load.ReviewsCount}}, gini: {{printf "%.2f" .Gini}}
+
+| User | Active | Reviews | Open | Share |
+|------|--------|---------|------|-------|
+{{range .Load.Members}}| {{md .Username}} ({{md .UserID}}) | {{.IsActive}} | {{.ReviewsCount}} | {{.OpenReviewsCount}} | {{percent .ReviewShare}} |
+{{end}}
+### Stale PRs
+{{if .StalePRs}}
+| PR | Name | Author | Age |
+|----|------|--------|-----|
+{{range .StalePRs}}| {{md .ID}} | {{md .Name}} | {{md .AuthorID}} | {{seconds .AgeSeconds}} |
+{{end}}{{else}}
+No stale PRs.
+{{end}}{{end}}`))
+
+var reportHTML = htmltemplate.Must(htmltemplate.New("report.html").Funcs(reportFuncs).Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Review report {{date .From}} — {{date .To}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Review report {{date .From}} — {{date .To}}</h1>
+{{range .Teams}}
+<h2>{{.TeamName}}</h2>
+<p>Members: {{.Load.MembersCount}} (inactive: {{.Load.InactiveMembersCount}}), open PRs: {{.Load.OpenPRs}}, merged PRs: {{.Load.MergedPRs}}</p>
+
+<h3>Merged PRs</h3>
+{{if .MergedPRs}}<table>
+<tr><th>PR</th><th>Name</th><th>Author</th><th>Lead time</th></tr>
+{{range .MergedPRs}}<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.AuthorID}}</td><td>{{leadTime .}}</td></tr>
+{{end}}</table>{{else}}<p>No merged PRs.</p>{{end}}
+
+<h3>Slowest reviews</h3>
+{{if .SlowestReviews}}<table>
+<tr><th>PR</th><th>Reviewer</th><th>Assigned</th><th>Verdict</th><th>Wait</th></tr>
+{{range .SlowestReviews}}<tr><td>{{.PullRequestID}}</td><td>{{.ReviewerID}}</td><td>{{date .AssignedAt}}</td><td>{{deref .Verdict}}</td><td>{{seconds .WaitSeconds}}</td></tr>
+{{end}}</table>{{else}}<p>No reviews.</p>{{end}}
+
+<h3>Load distribution</h3>
+<p>Reviews: {{.Load.ReviewsCount}}, gini: {{printf "%.2f" .Gini}}</p>
+<table>
+<tr><th>User</th><th>Active</th><th>Reviews</th><th>Open</th><th>Share</th></tr>
+{{range .Load.Members}}<tr><td>{{.Username}} ({{.UserID}})</td><td>{{.IsActive}}</td><td>{{.ReviewsCount}}</td><td>{{.OpenReviewsCount}}</td><td>{{percent .ReviewShare}}</td></tr>
+{{end}}</table>
+
+<h3>Stale PRs</h3>
+{{if .StalePRs}}<table>
+<tr><th>PR</th><th>Name</th><th>Author</th><th>Age</th></tr>
+{{range .StalePRs}}<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.AuthorID}}</td><td>{{seconds .AgeSeconds}}</td></tr>
+{{end}}</table>{{else}}<p>No stale PRs.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func WriteReportMarkdown(w io.Writer, r *models.Report) error {
+	return reportMarkdown.Execute(w, r)
+}
+
+func WriteReportHTML(w io.Writer, r *models.Report) error {
+	return reportHTML.Execute(w, r)
+}
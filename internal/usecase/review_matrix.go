@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// GetReviewMatrix матрица ревьювер x автор и пары, которые постоянно ревьюят друг друга
+func (s *StatService) GetReviewMatrix(filter models.StatFilter) (*models.ReviewMatrix, int, *Error) {
+	filter.Bucket = ""
+	if status, wErr := validateStatFilter(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	pairs, err := s.repo.GetReviewPairs(filter)
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	return buildReviewMatrix(filter, pairs), http.StatusOK, nil
+}
+
+func buildReviewMatrix(filter models.StatFilter, pairs []models.ReviewPair) *models.ReviewMatrix {
+	result := models.ReviewMatrix{
+		From:        filter.From,
+		To:          filter.To,
+		TeamName:    filter.TeamName,
+		Pairs:       pairs,
+		MutualPairs: make([]models.MutualPair, 0),
+	}
+	if result.Pairs == nil {
+		result.Pairs = make([]models.ReviewPair, 0)
+	}
+
+	reviewers, authors := make(map[string]int), make(map[string]int)
+	counts := make(map[[2]string]int64, len(pairs))
+	for _, p := range pairs {
+		reviewers[p.ReviewerID] = 0
+		authors[p.AuthorID] = 0
+		counts[[2]string{p.ReviewerID, p.AuthorID}] = p.Count
+	}
+	result.Reviewers = sortedKeys(reviewers)
+	result.Authors = sortedKeys(authors)
+
+	result.Counts = make([][]int64, len(result.Reviewers))
+	for i, reviewer := range result.Reviewers {
+		result.Counts[i] = make([]int64, len(result.Authors))
+		for j, author := range result.Authors {
+			result.Counts[i][j] = counts[[2]string{reviewer, author}]
+		}
+	}
+
+	for _, p := range pairs {
+		if p.ReviewerID >= p.AuthorID {
+			continue
+		}
+		if back := counts[[2]string{p.AuthorID, p.ReviewerID}]; back > 0 {
+			result.MutualPairs = append(result.MutualPairs, models.MutualPair{
+				UserA:   p.ReviewerID,
+				UserB:   p.AuthorID,
+				AReview: p.Count,
+				BReview: back,
+			})
+		}
+	}
+	sort.SliceStable(result.MutualPairs, func(i, j int) bool {
+		a, b := result.MutualPairs[i], result.MutualPairs[j]
+		return min(a.AReview, a.BReview) > min(b.AReview, b.BReview)
+	})
+
+	return &result
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMatrixCSV строки - ревьюверы, колонки - авторы
+func WriteMatrixCSV(w io.Writer, m *models.ReviewMatrix) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"reviewer\\author"}, m.Authors...)); err != nil {
+		return err
+	}
+	for i, reviewer := range m.Reviewers {
+		row := make([]string, 0, len(m.Authors)+1)
+		row = append(row, reviewer)
+		for _, c := range m.Counts[i] {
+			row = append(row, strconv.FormatInt(c, 10))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMatrixDOT граф для Graphviz: ребро ревьювер -> автор, толщина зависит от числа ревью
+func WriteMatrixDOT(w io.Writer, m *models.ReviewMatrix) error {
+	var maxCount int64 = 1
+	for _, p := range m.Pairs {
+		maxCount = max(maxCount, p.Count)
+	}
+
+	if _, err := fmt.Fprintln(w, "digraph reviews {"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, "  node [shape=box];"); err != nil {
+		return err
+	}
+	for _, p := range m.Pairs {
+		width := 1 + 4*float64(p.Count)/float64(maxCount)
+		if _, err := fmt.Fprintf(w, "  %s -> %s [label=\"%d\", penwidth=%.1f];\n",
+			strconv.Quote(p.ReviewerID), strconv.Quote(p.AuthorID), p.Count, width); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
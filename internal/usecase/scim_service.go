@@ -0,0 +1,443 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ScimService подмножество SCIM 2.0: User -> models.User, Group -> models.Team.
+// Все изменения идут через UserService/TeamService, чтобы сохранялись доменные правила
+type ScimService struct {
+	repo repository.Repository
+	us   *UserService
+	ts   *TeamService
+	l    logger.Logger
+}
+
+func NewScimService(repo repository.Repository, us *UserService, ts *TeamService, l logger.Logger) *ScimService {
+	return &ScimService{repo: repo, us: us, ts: ts, l: l}
+}
+
+func (s *ScimService) ListUsers(filter string, startIndex, count int) (*models.ScimListResponse, int, *Error) {
+	userFilter := models.UserFilter{}
+	if filter != "" {
+		attr, value, err := parseScimFilter(filter)
+		if err != nil {
+			return nil, http.StatusBadRequest, &Error{Code: "invalidFilter", Message: err.Error()}
+		}
+		switch strings.ToLower(attr) {
+		case "username":
+			userFilter.Username = value
+			userFilter.ExactUsername = true
+		case "id":
+			user, status, wErr := s.us.GetUser(value)
+			if status == http.StatusNotFound {
+				return newScimList(0, startIndex, []models.ScimUser{}), http.StatusOK, nil
+			}
+			if wErr != nil {
+				return nil, status, wErr
+			}
+			if count == 0 {
+				return newScimList(1, startIndex, []models.ScimUser{}), http.StatusOK, nil
+			}
+			return newScimList(1, startIndex, []models.ScimUser{toScimUser(*user)}), http.StatusOK, nil
+		case "active":
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, http.StatusBadRequest, &Error{Code: "invalidFilter", Message: "active must be boolean"}
+			}
+			userFilter.IsActive = &active
+		case "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:department", "department":
+			userFilter.TeamName = value
+		default:
+			return nil, http.StatusBadRequest, &Error{Code: "invalidFilter", Message: "unsupported attribute " + attr}
+		}
+	}
+
+	total, err := s.repo.CountUsers(userFilter)
+	if err != nil {
+		s.l.Errorf("Error in bd (count users). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	// count=0 - только totalResults (RFC 7644 3.4.2.4), Limit 0 в репозитории означает "без ограничения"
+	if count == 0 {
+		return newScimList(total, startIndex, []models.ScimUser{}), http.StatusOK, nil
+	}
+	userFilter.Offset = startIndex - 1
+	userFilter.Limit = count
+	users, status, wErr := s.us.ListUsers(userFilter)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+
+	resources := make([]models.ScimUser, len(users))
+	for i, u := range users {
+		resources[i] = toScimUser(u)
+	}
+	return newScimList(total, startIndex, resources), http.StatusOK, nil
+}
+
+func (s *ScimService) GetUser(id string) (*models.ScimUser, int, *Error) {
+	user, status, wErr := s.us.GetUser(id)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+	result := toScimUser(*user)
+	return &result, http.StatusOK, nil
+}
+
+func (s *ScimService) CreateUser(su models.ScimUser) (*models.ScimUser, int, *Error) {
+	users, err := s.repo.ListUsers(models.UserFilter{Username: su.UserName, ExactUsername: true, Limit: 1})
+	if err != nil {
+		s.l.Errorf("Error in bd (list users). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if len(users) != 0 {
+		return nil, http.StatusConflict, &Error{Code: "USER_EXISTS", Message: "userName already exists"}
+	}
+
+	user := models.User{
+		ID:       su.ExternalID,
+		Username: su.UserName,
+		IsActive: su.Active == nil || *su.Active,
+	}
+	if user.ID == "" {
+		if user.ID, err = newScimID(); err != nil {
+			s.l.Errorf("Error generate id. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+	}
+	if su.Enterprise != nil {
+		user.TeamName = su.Enterprise.Department
+	}
+
+	created, status, wErr := s.us.CreateUser(user)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+	result := toScimUser(*created)
+	return &result, http.StatusCreated, nil
+}
+
+func (s *ScimService) ReplaceUser(id string, su models.ScimUser) (*models.ScimUser, int, *Error) {
+	info := models.UpdateUserInfo{ID: id, Username: su.UserName}
+	if su.Enterprise != nil {
+		info.TeamName = su.Enterprise.Department
+	}
+	return s.updateUser(info, su.Active)
+}
+
+func (s *ScimService) PatchUser(id string, patch models.ScimPatch) (*models.ScimUser, int, *Error) {
+	info := models.UpdateUserInfo{ID: id}
+	var active *bool
+
+	for _, op := range patch.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			return nil, http.StatusBadRequest, &Error{Code: "invalidSyntax", Message: "unsupported op " + op.Op}
+		}
+
+		values := make(map[string]json.RawMessage)
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "value must be an object"}
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+
+		for path, raw := range values {
+			switch strings.ToLower(path) {
+			case "active":
+				v, err := parseScimBool(raw)
+				if err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: err.Error()}
+				}
+				active = &v
+			case "username":
+				if err := json.Unmarshal(raw, &info.Username); err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "userName must be a string"}
+				}
+			case "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:department":
+				if err := json.Unmarshal(raw, &info.TeamName); err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "department must be a string"}
+				}
+			case "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user":
+				var ext models.ScimEnterprise
+				if err := json.Unmarshal(raw, &ext); err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "invalid enterprise extension"}
+				}
+				info.TeamName = ext.Department
+			default:
+				return nil, http.StatusBadRequest, &Error{Code: "invalidPath", Message: "unsupported path " + path}
+			}
+		}
+	}
+
+	return s.updateUser(info, active)
+}
+
+// DeleteUser пользователя нельзя удалить из-за истории PR, поэтому deprovisioning = деактивация
+func (s *ScimService) DeleteUser(id string) (int, *Error) {
+	if _, status, wErr := s.us.UpdateUser(models.User{ID: id, IsActive: false}); wErr != nil {
+		return status, wErr
+	}
+	return http.StatusNoContent, nil
+}
+
+func (s *ScimService) updateUser(info models.UpdateUserInfo, active *bool) (*models.ScimUser, int, *Error) {
+	user, status, wErr := s.us.GetUser(info.ID)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+
+	if info.Username != "" || info.TeamName != "" {
+		if user, status, wErr = s.us.UpdateUserInfo(info); wErr != nil {
+			return nil, status, wErr
+		}
+	}
+
+	// деактивация через UserService, чтобы открытые ревью переназначились
+	if active != nil && *active != user.IsActive {
+		if _, status, wErr = s.us.UpdateUser(models.User{ID: info.ID, IsActive: *active}); wErr != nil {
+			return nil, status, wErr
+		}
+		user.IsActive = *active
+	}
+
+	result := toScimUser(*user)
+	return &result, http.StatusOK, nil
+}
+
+func (s *ScimService) ListGroups(filter string, startIndex, count int) (*models.ScimListResponse, int, *Error) {
+	names, err := s.repo.GetTeamNames()
+	if err != nil {
+		s.l.Errorf("Error in bd (get teams). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	if filter != "" {
+		attr, value, err := parseScimFilter(filter)
+		if err != nil {
+			return nil, http.StatusBadRequest, &Error{Code: "invalidFilter", Message: err.Error()}
+		}
+		switch strings.ToLower(attr) {
+		case "displayname", "id":
+		default:
+			return nil, http.StatusBadRequest, &Error{Code: "invalidFilter", Message: "unsupported attribute " + attr}
+		}
+		filtered := make([]string, 0, 1)
+		for _, name := range names {
+			if name == value {
+				filtered = append(filtered, name)
+			}
+		}
+		names = filtered
+	}
+
+	total := len(names)
+	from := min(startIndex-1, total)
+	to := min(from+count, total)
+
+	resources := make([]models.ScimGroup, 0, to-from)
+	for _, name := range names[from:to] {
+		team, status, wErr := s.ts.GetTeam(name)
+		if wErr != nil {
+			return nil, status, wErr
+		}
+		resources = append(resources, toScimGroup(*team))
+	}
+	return newScimList(int64(total), startIndex, resources), http.StatusOK, nil
+}
+
+func (s *ScimService) GetGroup(id string) (*models.ScimGroup, int, *Error) {
+	team, status, wErr := s.ts.GetTeam(id)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+	result := toScimGroup(*team)
+	return &result, http.StatusOK, nil
+}
+
+func (s *ScimService) CreateGroup(group models.ScimGroup) (*models.ScimGroup, int, *Error) {
+	if status, wErr := s.ts.AddTeam(&models.TeamWithMembers{TeamName: group.DisplayName}); wErr != nil {
+		return nil, status, wErr
+	}
+	if status, wErr := s.addMembers(group.DisplayName, group.Members); wErr != nil {
+		return nil, status, wErr
+	}
+
+	result, status, wErr := s.GetGroup(group.DisplayName)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+	return result, http.StatusCreated, nil
+}
+
+// PatchGroup добавление участника = перевод пользователя в команду.
+// Удалить участника нельзя: пользователь всегда состоит в какой-то команде
+func (s *ScimService) PatchGroup(id string, patch models.ScimPatch) (*models.ScimGroup, int, *Error) {
+	if _, status, wErr := s.ts.GetTeam(id); wErr != nil {
+		return nil, status, wErr
+	}
+
+	for _, op := range patch.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		case "remove":
+			return nil, http.StatusBadRequest, &Error{Code: "mutability",
+				Message: "users must belong to a team, add them to another group instead"}
+		default:
+			return nil, http.StatusBadRequest, &Error{Code: "invalidSyntax", Message: "unsupported op " + op.Op}
+		}
+
+		values := make(map[string]json.RawMessage)
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "value must be an object"}
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+
+		for path, raw := range values {
+			switch strings.ToLower(path) {
+			case "members":
+				var members []models.ScimMember
+				if err := json.Unmarshal(raw, &members); err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "members must be an array"}
+				}
+				if status, wErr := s.addMembers(id, members); wErr != nil {
+					return nil, status, wErr
+				}
+			case "displayname":
+				var name string
+				if err := json.Unmarshal(raw, &name); err != nil {
+					return nil, http.StatusBadRequest, &Error{Code: "invalidValue", Message: "displayName must be a string"}
+				}
+				if name == id {
+					continue
+				}
+				if _, status, wErr := s.ts.RenameTeam(models.RenameTeam{TeamName: id, NewTeamName: name}); wErr != nil {
+					return nil, status, wErr
+				}
+				id = name
+			default:
+				return nil, http.StatusBadRequest, &Error{Code: "invalidPath", Message: "unsupported path " + path}
+			}
+		}
+	}
+
+	return s.GetGroup(id)
+}
+
+func (s *ScimService) DeleteGroup(id string) (int, *Error) {
+	if _, status, wErr := s.ts.DeleteTeam(models.DeleteTeam{TeamName: id}); wErr != nil {
+		return status, wErr
+	}
+	return http.StatusNoContent, nil
+}
+
+func (s *ScimService) addMembers(teamName string, members []models.ScimMember) (int, *Error) {
+	for _, m := range members {
+		if _, status, wErr := s.us.UpdateUserInfo(models.UpdateUserInfo{ID: m.Value, TeamName: teamName}); wErr != nil {
+			return status, wErr
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func toScimUser(u models.User) models.ScimUser {
+	active := u.IsActive
+	return models.ScimUser{
+		Schemas:    []string{models.ScimUserSchema, models.ScimEnterpriseSchema},
+		ID:         u.ID,
+		UserName:   u.Username,
+		Active:     &active,
+		Enterprise: &models.ScimEnterprise{Department: u.TeamName},
+		Meta: &models.ScimMeta{
+			ResourceType: "User",
+			Created:      &u.CreatedAt,
+			LastModified: &u.UpdatedAt,
+		},
+	}
+}
+
+func toScimGroup(t models.TeamWithMembers) models.ScimGroup {
+	members := make([]models.ScimMember, len(t.Members))
+	for i, m := range t.Members {
+		members[i] = models.ScimMember{Value: m.ID, Display: m.Username}
+	}
+	return models.ScimGroup{
+		Schemas:     []string{models.ScimGroupSchema},
+		ID:          t.TeamName,
+		DisplayName: t.TeamName,
+		Members:     members,
+		Meta:        &models.ScimMeta{ResourceType: "Group"},
+	}
+}
+
+func newScimList(total int64, startIndex int, resources interface{}) *models.ScimListResponse {
+	items := 0
+	switch r := resources.(type) {
+	case []models.ScimUser:
+		items = len(r)
+	case []models.ScimGroup:
+		items = len(r)
+	}
+	return &models.ScimListResponse{
+		Schemas:      []string{models.ScimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: items,
+		Resources:    resources,
+	}
+}
+
+// parseScimFilter поддерживается только `attr eq "value"`
+func parseScimFilter(filter string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(filter), " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", fmt.Errorf("unsupported filter %q, only `attr eq value` is supported", filter)
+	}
+
+	value := strings.TrimSpace(parts[2])
+	if strings.HasPrefix(value, `"`) {
+		if err := json.Unmarshal([]byte(value), &value); err != nil {
+			return "", "", fmt.Errorf("invalid filter value %s", parts[2])
+		}
+	}
+	return parts[0], value, nil
+}
+
+// parseScimBool некоторые IdP (Azure AD) присылают boolean строкой "False"
+func parseScimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return false, errors.New("active must be boolean")
+	}
+	return strconv.ParseBool(str)
+}
+
+func newScimID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,198 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/clock"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"net/http"
+	"time"
+)
+
+// SLAScheduler ищет ревьюверов открытых PR, которые не оставили вердикт за SLA команды автора:
+// сначала напоминание ревьюверу, потом эскалация - уведомление лида или переназначение через UpdateReviewer.
+// Каждый шаг срабатывает один раз на назначение, даже если планировщик работает в нескольких репликах (sla_actions)
+type SLAScheduler struct {
+	repo  repository.Repository
+	prs   *PRService
+	l     logger.Logger
+	clock clock.Clock
+}
+
+func NewSLAScheduler(repo repository.Repository, prs *PRService, l logger.Logger, c clock.Clock) *SLAScheduler {
+	return &SLAScheduler{repo: repo, prs: prs, l: l, clock: c}
+}
+
+func (s *SLAScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Check(ctx); err != nil {
+			s.l.Errorf("Error check review sla. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check один проход по всем командам с SLA на момент clock.Now(). Возвращает число сработавших шагов
+func (s *SLAScheduler) Check(ctx context.Context) (int, error) {
+	slas, err := s.repo.ListReviewSLAs()
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	var fired int
+	for _, sla := range slas {
+		if ctx.Err() != nil {
+			break
+		}
+		remind, err := parseSLADuration(sla.RemindAfter)
+		if err != nil {
+			s.l.Warnf("Invalid remind_after %q of team %s", sla.RemindAfter, sla.TeamName)
+			continue
+		}
+		escalate, err := parseSLADuration(sla.EscalateAfter)
+		if err != nil {
+			s.l.Warnf("Invalid escalate_after %q of team %s", sla.EscalateAfter, sla.TeamName)
+			continue
+		}
+		first := remind
+		if first == 0 || escalate > 0 && escalate < first {
+			first = escalate
+		}
+
+		reviews, err := s.repo.GetStaleReviews(sla.TeamName, now.Add(-first))
+		if err != nil {
+			return fired, err
+		}
+		for _, review := range reviews {
+			age := now.Sub(review.AssignedAt)
+			var ok bool
+			// напоминание всегда первое: если оно сработало сейчас (в т.ч. пропущенное, пока планировщик
+			// не работал), эскалация - на следующем проходе
+			if remind > 0 && age >= remind {
+				if ok, err = s.remind(sla, review, now); err != nil {
+					return fired, err
+				}
+			}
+			if !ok && escalate > 0 && age >= escalate {
+				if ok, err = s.escalate(sla, review, now); err != nil {
+					return fired, err
+				}
+			}
+			if ok {
+				fired++
+			}
+		}
+	}
+	return fired, nil
+}
+
+func (s *SLAScheduler) remind(sla models.ReviewSLA, review models.PrReviewer, now time.Time) (bool, error) {
+	action := slaAction(review, "reminder", now)
+	var fired bool
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		claimed, err := tx.AddSLAAction(&action)
+		if err != nil || !claimed {
+			return err
+		}
+		fired = true
+		return tx.AddOutboxEvents([]models.Event{slaEvent(models.EventReviewReminder, sla.TeamName, action, now)})
+	})
+	return fired, err
+}
+
+// escalate лид уведомляется в одной транзакции с отметкой шага. Если лида нет, он неактивен
+// или сам ревьювер - переназначение
+func (s *SLAScheduler) escalate(sla models.ReviewSLA, review models.PrReviewer, now time.Time) (bool, error) {
+	action := slaAction(review, "escalation", now)
+	if sla.Escalation == "lead" && sla.LeadID != nil && *sla.LeadID != review.ReviewerID {
+		lead, notFound, err := s.repo.GetUserByID(*sla.LeadID)
+		if err != nil && !notFound {
+			return false, err
+		}
+		if !notFound && lead.IsActive {
+			action.Action, action.LeadID = "lead", lead.ID
+			var fired bool
+			err := s.repo.Transaction(func(tx repository.Repository) error {
+				claimed, err := tx.AddSLAAction(&action)
+				if err != nil || !claimed {
+					return err
+				}
+				fired = true
+				return tx.AddOutboxEvents([]models.Event{slaEvent(models.EventReviewEscalated, sla.TeamName, action, now)})
+			})
+			return fired, err
+		}
+	}
+	return s.reassign(sla, action, now)
+}
+
+// reassign отметка шага, переназначение и событие эскалации в одной транзакции: другая реплика ждет
+// на ключе sla_actions и того же ревьювера не переназначит. При ошибке БД откатывается все и шаг повторится,
+// если замены нет - отметка остается (как и ручной reassign, см. NO_CANDIDATE)
+func (s *SLAScheduler) reassign(sla models.ReviewSLA, action models.SLAAction, now time.Time) (bool, error) {
+	var fired bool
+	var wErr *Error
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		claimed, err := tx.AddSLAAction(&action)
+		if err != nil || !claimed {
+			return err
+		}
+
+		prs := &PRService{repo: tx, l: s.prs.l, m: s.prs.m}
+		updated, status, uErr := prs.UpdateReviewer(models.UpdateReviewer{
+			PullRequestID: action.PullRequestID,
+			OldReviewerID: action.ReviewerID,
+		})
+		if uErr != nil {
+			wErr = uErr
+			if status == http.StatusInternalServerError {
+				return errSLARetry
+			}
+			return nil
+		}
+
+		fired = true
+		action.Action, action.NewReviewerID = "reassign", updated.ReplacedBy
+		return tx.AddOutboxEvents([]models.Event{slaEvent(models.EventReviewEscalated, sla.TeamName, action, now)})
+	})
+	if wErr != nil {
+		s.l.Warnf("SLA escalation of %s on PR %s: reassign failed: %s", action.ReviewerID, action.PullRequestID, wErr.Code)
+		return false, nil
+	}
+	return fired, err
+}
+
+// errSLARetry откатывает отметку шага, чтобы он повторился на следующем проходе
+var errSLARetry = errors.New("sla action will be retried")
+
+func slaAction(review models.PrReviewer, stage string, now time.Time) models.SLAAction {
+	return models.SLAAction{
+		PullRequestID: review.PullRequestID,
+		ReviewerID:    review.ReviewerID,
+		AssignedAt:    review.AssignedAt,
+		Stage:         stage,
+		CreatedAt:     now,
+	}
+}
+
+func slaEvent(eventType, teamName string, action models.SLAAction, now time.Time) models.Event {
+	users := []string{action.ReviewerID}
+	for _, id := range []string{action.LeadID, action.NewReviewerID} {
+		if id != "" {
+			users = append(users, id)
+		}
+	}
+	event := newEvent(eventType, action.PullRequestID, teamName, users, action)
+	event.OccurredAt = now.UTC()
+	return event
+}
@@ -0,0 +1,226 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/clock"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+var slaStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// newSLARepo PR pr-1 автора u1, ревьюверы u2 и u3 назначены в slaStart. SLA команды: напомнить через 24h,
+// эскалировать через 72h
+func newSLARepo(c *clock.Fake, escalation string, leadID *string) *memRepo {
+	repo := newMemRepo()
+	repo.now = c.Now
+	repo.prs["pr-1"] = models.PullRequest{ID: "pr-1", Name: "Add cache", AuthorID: "u1", Status: "OPEN", CreatedAt: slaStart}
+	repo.reviewers["pr-1"] = []models.PrReviewer{
+		{PullRequestID: "pr-1", ReviewerID: "u2", AssignedAt: slaStart},
+		{PullRequestID: "pr-1", ReviewerID: "u3", AssignedAt: slaStart},
+	}
+	repo.slas = []models.ReviewSLA{{TeamName: "backend", RemindAfter: "24h", EscalateAfter: "72h", Escalation: escalation, LeadID: leadID}}
+	return repo
+}
+
+func newTestScheduler(repo *memRepo, c *clock.Fake) *SLAScheduler {
+	l := logger.New()
+	return NewSLAScheduler(repo, NewPRService(repo, l, nopMetrics{}), l, c)
+}
+
+func checkFired(t *testing.T, s *SLAScheduler, want int) {
+	t.Helper()
+	fired, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fired != want {
+		t.Fatalf("fired = %d, want %d", fired, want)
+	}
+}
+
+// slaActions события напоминаний и эскалаций после from
+func slaActions(t *testing.T, repo *memRepo, from int) []models.SLAAction {
+	t.Helper()
+	var actions []models.SLAAction
+	for _, e := range repo.events[from:] {
+		if e.Type != models.EventReviewReminder && e.Type != models.EventReviewEscalated {
+			continue
+		}
+		var action models.SLAAction
+		if err := json.Unmarshal(e.Data, &action); err != nil {
+			t.Fatal(err)
+		}
+		action.Stage = e.Type
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func TestSLASchedulerReminder(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	repo := newSLARepo(c, "reassign", nil)
+	s := newTestScheduler(repo, c)
+
+	c.Advance(24*time.Hour - time.Minute)
+	checkFired(t, s, 0)
+
+	c.Advance(time.Minute)
+	checkFired(t, s, 2)
+	// следующие проходы до срока эскалации ничего не шлют
+	for range 3 {
+		c.Advance(time.Hour)
+		checkFired(t, s, 0)
+	}
+
+	actions := slaActions(t, repo, 0)
+	if len(actions) != 2 || actions[0].ReviewerID != "u2" || actions[1].ReviewerID != "u3" {
+		t.Fatalf("actions = %+v", actions)
+	}
+	for _, a := range actions {
+		if a.Stage != models.EventReviewReminder {
+			t.Fatalf("action = %+v, want reminder", a)
+		}
+	}
+}
+
+func TestSLASchedulerReassign(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	repo := newSLARepo(c, "reassign", nil)
+	s := newTestScheduler(repo, c)
+
+	c.Advance(24 * time.Hour)
+	checkFired(t, s, 2)
+	// u3 оставил вердикт, эскалируется только u2
+	verdict := "APPROVED"
+	repo.reviewers["pr-1"][1].Verdict = &verdict
+
+	c.Advance(48 * time.Hour)
+	events := len(repo.events)
+	checkFired(t, s, 1)
+	checkFired(t, s, 0)
+
+	actions := slaActions(t, repo, events)
+	if len(actions) != 1 || actions[0].Stage != models.EventReviewEscalated || actions[0].Action != "reassign" ||
+		actions[0].ReviewerID != "u2" || actions[0].NewReviewerID != "u4" {
+		t.Fatalf("actions = %+v", actions)
+	}
+	if ids, _ := repo.GetUsersIDByPRID("pr-1"); len(ids) != 2 || ids[0] != "u4" || ids[1] != "u3" {
+		t.Fatalf("reviewers = %v", ids)
+	}
+
+	// для u4 отсчет начинается с переназначения
+	reassignedAt := c.Now()
+	c.Advance(24*time.Hour - time.Minute)
+	checkFired(t, s, 0)
+	c.Advance(time.Minute)
+	events = len(repo.events)
+	checkFired(t, s, 1)
+	actions = slaActions(t, repo, events)
+	if len(actions) != 1 || actions[0].Stage != models.EventReviewReminder || actions[0].ReviewerID != "u4" ||
+		!actions[0].AssignedAt.Equal(reassignedAt) {
+		t.Fatalf("actions = %+v", actions)
+	}
+}
+
+func TestSLASchedulerLead(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	lead := "u4"
+	repo := newSLARepo(c, "lead", &lead)
+	s := newTestScheduler(repo, c)
+
+	c.Advance(24 * time.Hour)
+	checkFired(t, s, 2)
+	c.Advance(48 * time.Hour)
+	events := len(repo.events)
+	checkFired(t, s, 2)
+	checkFired(t, s, 0)
+
+	actions := slaActions(t, repo, events)
+	if len(actions) != 2 {
+		t.Fatalf("actions = %+v", actions)
+	}
+	for _, a := range actions {
+		if a.Stage != models.EventReviewEscalated || a.Action != "lead" || a.LeadID != "u4" || a.NewReviewerID != "" {
+			t.Fatalf("action = %+v, want lead escalation", a)
+		}
+	}
+	// ревьюверы остаются
+	if ids, _ := repo.GetUsersIDByPRID("pr-1"); len(ids) != 2 || ids[0] != "u2" || ids[1] != "u3" {
+		t.Fatalf("reviewers = %v", ids)
+	}
+}
+
+func TestSLASchedulerLeadIsReviewer(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	lead := "u2"
+	repo := newSLARepo(c, "lead", &lead)
+	s := newTestScheduler(repo, c)
+
+	c.Advance(24 * time.Hour)
+	checkFired(t, s, 2)
+	c.Advance(48 * time.Hour)
+	events := len(repo.events)
+	checkFired(t, s, 2)
+
+	actions := slaActions(t, repo, events)
+	if len(actions) != 2 || actions[0].ReviewerID != "u2" || actions[0].Action != "reassign" || actions[0].NewReviewerID != "u4" ||
+		actions[1].ReviewerID != "u3" || actions[1].Action != "lead" || actions[1].LeadID != "u2" {
+		t.Fatalf("actions = %+v", actions)
+	}
+}
+
+func TestSLASchedulerMissedReminder(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	repo := newSLARepo(c, "reassign", nil)
+	s := newTestScheduler(repo, c)
+
+	// планировщик не работал, прошли оба срока: сначала напоминание, эскалация на следующем проходе
+	c.Advance(80 * time.Hour)
+	checkFired(t, s, 2)
+	actions := slaActions(t, repo, 0)
+	if len(actions) != 2 || actions[0].Stage != models.EventReviewReminder || actions[1].Stage != models.EventReviewReminder {
+		t.Fatalf("actions = %+v", actions)
+	}
+
+	events := len(repo.events)
+	c.Advance(time.Minute)
+	checkFired(t, s, 2)
+	actions = slaActions(t, repo, events)
+	if len(actions) != 2 || actions[0].Stage != models.EventReviewEscalated || actions[0].NewReviewerID != "u4" ||
+		actions[1].Stage != models.EventReviewEscalated || actions[1].NewReviewerID != "u2" {
+		t.Fatalf("actions = %+v", actions)
+	}
+	checkFired(t, s, 0)
+}
+
+func TestSLASchedulerReplicas(t *testing.T) {
+	c := clock.NewFake(slaStart)
+	repo := newSLARepo(c, "reassign", nil)
+	first, second := newTestScheduler(repo, c), newTestScheduler(repo, c)
+
+	c.Advance(24 * time.Hour)
+	checkFired(t, first, 2)
+	checkFired(t, second, 0)
+
+	c.Advance(48 * time.Hour)
+	checkFired(t, second, 2)
+	checkFired(t, first, 0)
+
+	var reminders, escalations int
+	for _, a := range slaActions(t, repo, 0) {
+		switch a.Stage {
+		case models.EventReviewReminder:
+			reminders++
+		case models.EventReviewEscalated:
+			escalations++
+		}
+	}
+	if reminders != 2 || escalations != 2 {
+		t.Fatalf("reminders = %d, escalations = %d", reminders, escalations)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/ashurov-imomali/pr-service/internal/repository"
 	"github.com/ashurov-imomali/pr-service/pkg/logger"
 	"golang.org/x/sync/errgroup"
+	"net/http"
+	"time"
 )
 
 type StatService struct {
@@ -16,13 +18,18 @@ func NewStatService(repo repository.Repository, l logger.Logger) *StatService {
 	return &StatService{repo: repo, l: l}
 }
 
-func (s *StatService) GetGeneralStat() (*models.GeneralStats, *Error) {
-	var result models.GeneralStats
+func (s *StatService) GetGeneralStat(filter models.StatFilter) (*models.GeneralStats, int, *Error) {
+	if status, wErr := validateDayWindow(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	result := models.GeneralStats{From: filter.From, To: filter.To}
+	total := models.StatFilter{From: filter.From, To: filter.To}
 
 	gr := errgroup.Group{}
 
 	gr.Go(func() error {
-		stats, err := s.repo.GetUsersStat()
+		stats, err := s.repo.GetUsersStat(total)
 		if err != nil {
 			s.l.Errorf("Error in bd. Err %v", err)
 			return err
@@ -33,27 +40,185 @@ func (s *StatService) GetGeneralStat() (*models.GeneralStats, *Error) {
 	})
 
 	gr.Go(func() error {
-		stats, err := s.repo.GetPRStats()
+		stats, err := s.repo.GetPRStats(total)
 		if err != nil {
 			s.l.Errorf("Error in bd. Err %v", err)
 			return err
 
 		}
-		result.PRStats = stats
+		if len(stats) != 0 {
+			result.PRStats = stats[0]
+		}
 		return nil
 	})
 
+	if filter.Bucket != "" {
+		gr.Go(func() error {
+			stats, err := s.repo.GetUsersStat(filter)
+			if err != nil {
+				s.l.Errorf("Error in bd. Err %v", err)
+				return err
+			}
+			result.UsersStatBuckets = stats
+			return nil
+		})
+
+		gr.Go(func() error {
+			stats, err := s.repo.GetPRStats(filter)
+			if err != nil {
+				s.l.Errorf("Error in bd. Err %v", err)
+				return err
+			}
+			result.PRStatsBuckets = stats
+			return nil
+		})
+	}
+
 	if err := gr.Wait(); err != nil {
-		return nil, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
 
-	return &result, nil
+	return &result, http.StatusOK, nil
 }
 
-func (s *StatService) GetUserStat(id string) (*models.UserStat, *Error) {
-	stat, err := s.repo.GetUserStat(id)
+func (s *StatService) GetUserStat(id string, filter models.StatFilter) (*models.UserStat, int, *Error) {
+	if status, wErr := validateDayWindow(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	stat, err := s.repo.GetUserStat(id, filter)
 	if err != nil {
-		return nil, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	if filter.Bucket != "" {
+		if stat.Buckets, err = s.repo.GetUserStatBuckets(id, filter); err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+	}
+	return stat, http.StatusOK, nil
+}
+
+func validateStatFilter(filter models.StatFilter) (int, *Error) {
+	switch filter.Bucket {
+	case "", "day", "week", "month":
+	default:
+		return http.StatusBadRequest, &Error{Code: "INVALID_BUCKET", Message: "bucket must be day, week or month"}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return http.StatusBadRequest, &Error{Code: "INVALID_PERIOD", Message: "from must be before to"}
+	}
+	return http.StatusOK, nil
+}
+
+// validateDayWindow для отчетов по дневным агрегатам: границы только по началу дня UTC,
+// иначе часть дня молча попала бы в период или выпала из него
+func validateDayWindow(filter models.StatFilter) (int, *Error) {
+	for _, t := range []*time.Time{filter.From, filter.To} {
+		if t != nil && !t.Equal(t.UTC().Truncate(24*time.Hour)) {
+			return http.StatusBadRequest, &Error{Code: "INVALID_PERIOD", Message: "from and to must be UTC day boundaries (YYYY-MM-DD)"}
+		}
+	}
+	return validateStatFilter(filter)
+}
+
+// GetTurnaround lead time (created_at -> merged_at), время до первого ревью и время ответа ревьювера
+// (assigned_at -> reviewed_at) в секундах, p50/p90, в разрезе user, team или repository
+func (s *StatService) GetTurnaround(groupBy string, filter models.StatFilter) (*models.TurnaroundStats, int, *Error) {
+	if groupBy == "" {
+		groupBy = "user"
+	}
+	switch groupBy {
+	case "user", "team", "repository":
+	default:
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_GROUP_BY", Message: "group_by must be user, team or repository"}
+	}
+	if status, wErr := validateStatFilter(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	rows, err := s.repo.GetTurnaround(groupBy, filter)
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	result := models.TurnaroundStats{
+		GroupBy: groupBy,
+		From:    filter.From,
+		To:      filter.To,
+		Items:   make([]models.TurnaroundStat, 0),
+	}
+	index := make(map[string]int)
+	for _, row := range rows {
+		i, ok := index[row.Key]
+		if !ok {
+			i = len(result.Items)
+			index[row.Key] = i
+			result.Items = append(result.Items, models.TurnaroundStat{Key: row.Key})
+		}
+
+		stat := models.DurationStat{Count: row.Count, P50: row.P50, P90: row.P90}
+		switch row.Metric {
+		case "lead_time":
+			result.Items[i].LeadTime = stat
+		case "time_to_first_review":
+			result.Items[i].TimeToFirstReview = stat
+		case "response_time":
+			result.Items[i].ResponseTime = stat
+		}
+	}
+	return &result, http.StatusOK, nil
+}
+
+// GetTeamStats статистика по одной или нескольким командам для сравнения
+func (s *StatService) GetTeamStats(teamNames []string, filter models.StatFilter) ([]models.TeamStat, int, *Error) {
+	if status, wErr := validateDayWindow(filter); wErr != nil {
+		return nil, status, wErr
+	}
+
+	result := make([]models.TeamStat, 0, len(teamNames))
+	for _, teamName := range teamNames {
+		_, notFound, err := s.repo.GetTeam(teamName)
+		if notFound {
+			s.l.Warnf("Team not found. teamName:%s", teamName)
+			return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+		}
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+
+		stat, err := s.repo.GetTeamStat(teamName, filter)
+		if err != nil {
+			s.l.Errorf("Error in bd. Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+
+		for _, m := range stat.Members {
+			stat.MembersCount++
+			if !m.IsActive {
+				stat.InactiveMembersCount++
+			}
+			stat.ReviewsCount += m.ReviewsCount
+		}
+		if stat.ReviewsCount != 0 {
+			for i := range stat.Members {
+				stat.Members[i].ReviewShare = float64(stat.Members[i].ReviewsCount) / float64(stat.ReviewsCount)
+			}
+		}
+		result = append(result, *stat)
+	}
+	return result, http.StatusOK, nil
+}
+
+// RebuildStats пересчитывает дневные агрегаты, на которых построены отчеты
+func (s *StatService) RebuildStats() (int, *Error) {
+	if err := s.repo.RebuildStats(); err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
-	return stat, nil
+	return http.StatusOK, nil
 }
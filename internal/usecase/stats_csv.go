@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"encoding/csv"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteGeneralStatsCSV одна таблица для обоих разделов: section = pr_stats или users_stat
+func WriteGeneralStatsCSV(w io.Writer, s *models.GeneralStats) error {
+	rows := [][]string{{"section", "bucket", "user_id", "pr_count", "total", "open", "merge", "bot_authored"}}
+
+	prStats := append([]models.PRStats{s.PRStats}, s.PRStatsBuckets...)
+	for _, p := range prStats {
+		rows = append(rows, []string{"pr_stats", csvTime(p.Bucket), "", "",
+			csvInt(p.Total), csvInt(p.Open), csvInt(p.Merge), csvInt(p.BotAuthored)})
+	}
+	for _, users := range [][]models.UsersStat{s.UsersStat, s.UsersStatBuckets} {
+		for _, u := range users {
+			rows = append(rows, []string{"users_stat", csvTime(u.Bucket), u.UserID, csvInt(u.PRCount), "", "", "", ""})
+		}
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteUserStatCSV первая строка - итог за период (пустой bucket), дальше по периодам
+func WriteUserStatCSV(w io.Writer, s *models.UserStat) error {
+	rows := [][]string{
+		{"user_id", "bucket", "pull_requests_count", "reviews_count", "merged_reviews_count", "open_reviews_count"},
+		{s.ID, "", csvInt(s.PullRequestsCount), csvInt(s.ReviewsCount), csvInt(s.MergedReviewsCount), csvInt(s.OpenReviewsCount)},
+	}
+	for _, b := range s.Buckets {
+		rows = append(rows, []string{s.ID, csvTime(&b.Bucket), csvInt(b.PullRequestsCount),
+			csvInt(b.ReviewsCount), csvInt(b.MergedReviewsCount), csvInt(b.OpenReviewsCount)})
+	}
+	return writeCSV(w, rows)
+}
+
+func WriteTurnaroundCSV(w io.Writer, s *models.TurnaroundStats) error {
+	rows := [][]string{{s.GroupBy,
+		"lead_time_count", "lead_time_p50_seconds", "lead_time_p90_seconds",
+		"time_to_first_review_count", "time_to_first_review_p50_seconds", "time_to_first_review_p90_seconds",
+		"reviewer_response_time_count", "reviewer_response_time_p50_seconds", "reviewer_response_time_p90_seconds"}}
+	for _, item := range s.Items {
+		row := []string{item.Key}
+		for _, d := range []models.DurationStat{item.LeadTime, item.TimeToFirstReview, item.ResponseTime} {
+			row = append(row, csvInt(d.Count), csvFloat(d.P50), csvFloat(d.P90))
+		}
+		rows = append(rows, row)
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteTeamStatsCSV строка на участника, командные итоги повторяются в каждой строке
+func WriteTeamStatsCSV(w io.Writer, stats []models.TeamStat) error {
+	rows := [][]string{{"team_name", "open_prs", "merged_prs", "team_reviews_count",
+		"user_id", "username", "is_active", "reviews_count", "open_reviews_count", "review_share"}}
+	for _, t := range stats {
+		for _, m := range t.Members {
+			rows = append(rows, []string{t.TeamName, csvInt(t.OpenPRs), csvInt(t.MergedPRs), csvInt(t.ReviewsCount),
+				m.UserID, m.Username, strconv.FormatBool(m.IsActive),
+				csvInt(m.ReviewsCount), csvInt(m.OpenReviewsCount), csvFloat(m.ReviewShare)})
+		}
+	}
+	return writeCSV(w, rows)
+}
+
+func WriteFairnessCSV(w io.Writer, r *models.FairnessReport) error {
+	rows := [][]string{{"team_name", "mean", "std_dev", "gini",
+		"user_id", "assignments", "deviation", "over_buckets", "under_buckets", "flag"}}
+	for _, t := range r.Teams {
+		for _, m := range t.Members {
+			rows = append(rows, []string{t.TeamName, csvFloat(t.Mean), csvFloat(t.StdDev), csvFloat(t.Gini),
+				m.UserID, csvInt(m.Assignments), csvFloat(m.Deviation),
+				strconv.Itoa(m.OverBuckets), strconv.Itoa(m.UnderBuckets), m.Flag})
+		}
+	}
+	return writeCSV(w, rows)
+}
+
+func writeCSV(w io.Writer, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func csvInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func csvFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,318 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// deliveryMaxAttempts после стольких неудачных попыток доставка помечается FAILED
+	deliveryMaxAttempts = 8
+	// deliveryBackoff задержка перед второй попыткой, дальше удваивается до deliveryMaxBackoff
+	deliveryBackoff    = 10 * time.Second
+	deliveryMaxBackoff = time.Hour
+	// deliveryLease сколько доставка считается занятой отправляющей репликой
+	deliveryLease     = time.Minute
+	deliveryBatchSize = 50
+)
+
+// SubscriptionService исходящие вебхуки: подписки, журнал доставок и фоновая отправка с повторами
+type SubscriptionService struct {
+	repo   repository.Repository
+	l      logger.Logger
+	client *http.Client
+}
+
+func NewSubscriptionService(repo repository.Repository, l logger.Logger) *SubscriptionService {
+	return &SubscriptionService{repo: repo, l: l, client: outboundClient()}
+}
+
+func (s *SubscriptionService) CreateSubscription(sub models.WebhookSubscription) (*models.WebhookSubscription, int, *Error) {
+	if !validHTTPURL(sub.URL) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_URL", Message: "url must be absolute http(s) url"}
+	}
+	if sub.Secret == "" {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_SECRET", Message: "secret is required"}
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	for _, event := range sub.Events {
+		if !slices.Contains(models.EventTypes, event) {
+			return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_EVENT",
+				Message: "events must be from: " + strings.Join(models.EventTypes, ", ")}
+		}
+	}
+
+	sub.ID = 0
+	if err := s.repo.CreateWebhookSubscription(&sub); err != nil {
+		s.l.Errorf("Error in bd (create subscription). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	sub.Secret = ""
+	return &sub, http.StatusCreated, nil
+}
+
+func (s *SubscriptionService) ListSubscriptions() ([]models.WebhookSubscription, int, *Error) {
+	subs, err := s.repo.ListWebhookSubscriptions()
+	if err != nil {
+		s.l.Errorf("Error in bd (list subscriptions). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	if subs == nil {
+		subs = []models.WebhookSubscription{}
+	}
+	return subs, http.StatusOK, nil
+}
+
+func (s *SubscriptionService) DeleteSubscription(id int64) (int, *Error) {
+	notFound, err := s.repo.DeleteWebhookSubscription(id)
+	if notFound {
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (delete subscription). Err %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *SubscriptionService) ListDeliveries(filter models.DeliveryFilter) ([]models.SubscriptionDelivery, int, *Error) {
+	switch filter.Status {
+	case "", "PENDING", "SUCCEEDED", "FAILED":
+	default:
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_STATUS", Message: "status must be PENDING, SUCCEEDED or FAILED"}
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+
+	deliveries, err := s.repo.ListSubscriptionDeliveries(filter)
+	if err != nil {
+		s.l.Errorf("Error in bd (list deliveries). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if deliveries == nil {
+		deliveries = []models.SubscriptionDelivery{}
+	}
+	return deliveries, http.StatusOK, nil
+}
+
+func (s *SubscriptionService) ReplayDeliveries(replay models.ReplayDeliveries) (int64, int, *Error) {
+	if replay.DeliveryID == 0 && replay.SubscriptionID == 0 {
+		return 0, http.StatusBadRequest, &Error{Code: "INVALID_REPLAY", Message: "delivery_id or subscription_id is required"}
+	}
+	count, err := s.repo.ReplaySubscriptionDeliveries(replay)
+	if err != nil {
+		s.l.Errorf("Error in bd (replay deliveries). Err %v", err)
+		return 0, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return count, http.StatusOK, nil
+}
+
+func (s *SubscriptionService) Name() string {
+	return "webhook"
+}
+
+// Send ставит событие в очередь доставки каждой подписке, чей фильтр его пропускает.
+// Повтор события релеем новых доставок не создает (event_id)
+func (s *SubscriptionService) Send(_ context.Context, event models.Event) error {
+	subs, err := s.repo.ListWebhookSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var deliveries []models.SubscriptionDelivery
+	for _, sub := range subs {
+		if len(sub.Events) != 0 && !slices.Contains(sub.Events, event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, models.SubscriptionDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         "PENDING",
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	return s.repo.CreateSubscriptionDeliveries(deliveries)
+}
+
+func (s *SubscriptionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.DeliverDue(ctx); err != nil {
+			s.l.Errorf("Error deliver webhooks. Err %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue отправляет все доставки, которым пора уходить, пачками по deliveryBatchSize
+func (s *SubscriptionService) DeliverDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		deliveries, err := s.repo.ClaimSubscriptionDeliveries(deliveryBatchSize, deliveryLease)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		subs := make(map[int64]*models.WebhookSubscription)
+		for i := range deliveries {
+			d := &deliveries[i]
+			sub, ok := subs[d.SubscriptionID]
+			if !ok {
+				if sub, _, err = s.repo.GetWebhookSubscription(d.SubscriptionID); err != nil {
+					// подписку удалили, доставки удалятся каскадом
+					continue
+				}
+				subs[d.SubscriptionID] = sub
+			}
+
+			s.deliver(ctx, sub, d)
+			if err := s.repo.UpdateSubscriptionDelivery(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SubscriptionService) deliver(ctx context.Context, sub *models.WebhookSubscription, d *models.SubscriptionDelivery) {
+	d.Attempts++
+	status, err := s.post(ctx, sub, d)
+	if status != 0 {
+		d.ResponseStatus = &status
+	}
+
+	now := time.Now()
+	if err == nil {
+		d.Status, d.LastError, d.DeliveredAt = "SUCCEEDED", "", &now
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= deliveryMaxAttempts {
+		d.Status = "FAILED"
+		s.l.Warnf("Webhook delivery %d to %s failed after %d attempts: %v", d.ID, sub.URL, d.Attempts, err)
+		return
+	}
+	d.NextAttemptAt = now.Add(deliveryDelay(d.Attempts))
+}
+
+func (s *SubscriptionService) post(ctx context.Context, sub *models.WebhookSubscription, d *models.SubscriptionDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pr-service-webhook")
+	req.Header.Set("X-PR-Service-Event", d.EventType)
+	req.Header.Set("X-PR-Service-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-PR-Service-Signature-256", "sha256="+signPayload(sub.Secret, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// validHTTPURL адрес должен быть внешним: loopback, частные и link-local адреса отклоняются
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return publicAddr(addr)
+	}
+	return true
+}
+
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+// outboundClient для исходящих вебхуков. Адрес проверяется и при соединении: имя из validHTTPURL
+// может резолвиться во внутренний адрес. Прокси из окружения не используется, иначе проверялся бы адрес прокси
+func outboundClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addr.Addr()) {
+				return fmt.Errorf("address %s is not allowed", addr.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// deliveryDelay экспоненциальная задержка после attempts неудачных попыток: 10s, 20s, 40s ... до часа
+func deliveryDelay(attempts int) time.Duration {
+	delay := deliveryBackoff
+	for i := 1; i < attempts && delay < deliveryMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, deliveryMaxBackoff)
+}
+
+// signPayload hex(HMAC-SHA256(secret, body)), как X-Hub-Signature-256 у GitHub
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,11 +1,13 @@
 package usecase
 
 import (
+	"fmt"
 	"github.com/ashurov-imomali/pr-service/internal/models"
 	"github.com/ashurov-imomali/pr-service/internal/repository"
 	"github.com/ashurov-imomali/pr-service/pkg/logger"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type TeamService struct {
@@ -25,6 +27,13 @@ func (s *TeamService) AddTeam(twm *models.TeamWithMembers) (int, *Error) {
 
 	for i := 0; i < len(twm.Members); i++ {
 		twm.Members[i].TeamName = twm.TeamName
+		if twm.Members[i].AccountType == "" {
+			twm.Members[i].AccountType = "HUMAN"
+		}
+		if !validAccountType(twm.Members[i].AccountType) {
+			s.l.Warnf("incorrect account type: %s", twm.Members[i].AccountType)
+			return http.StatusUnprocessableEntity, &Error{Code: "INVALID_ACCOUNT_TYPE"}
+		}
 	}
 
 	err := s.repo.AddTeam(twm)
@@ -52,7 +61,15 @@ func (s *TeamService) GetTeam(teamName string) (*models.TeamWithMembers, int, *E
 }
 
 func (s *TeamService) DeactivateTeam(teamName string) ([]models.User, int, *Error) {
-	deactiveUsers, notFound, err := s.repo.DeactivateTeam(teamName)
+	var deactiveUsers []models.User
+	var notFound bool
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		var err error
+		if deactiveUsers, notFound, err = tx.DeactivateTeam(teamName); notFound || err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents([]models.Event{teamDeactivatedEvent(teamName, deactiveUsers)})
+	})
 	if notFound {
 		return nil, http.StatusNotFound, &Error{Code: "TEAM_NOT_FOUND"}
 	}
@@ -63,3 +80,188 @@ func (s *TeamService) DeactivateTeam(teamName string) ([]models.User, int, *Erro
 
 	return deactiveUsers, http.StatusOK, nil
 }
+
+func (s *TeamService) RenameTeam(rename models.RenameTeam) (*models.TeamWithMembers, int, *Error) {
+	if len(strings.TrimSpace(rename.NewTeamName)) == 0 {
+		s.l.Warnf("incorrect team name: %s", rename.NewTeamName)
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_TEAM_NAME"}
+	}
+
+	_, notFound, err := s.repo.GetTeam(rename.NewTeamName)
+	if err != nil && !notFound {
+		s.l.Errorf("Error in DB (get team). Error: %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if !notFound {
+		return nil, http.StatusBadRequest, &Error{Code: "TEAM_EXISTS", Message: "team_name already exists"}
+	}
+
+	notFound, err = s.repo.RenameTeam(rename.TeamName, rename.NewTeamName)
+	if notFound {
+		s.l.Warnf("Team not found. teamName:%s", rename.TeamName)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (rename team). Error: %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	return s.GetTeam(rename.NewTeamName)
+}
+
+func (s *TeamService) DeleteTeam(del models.DeleteTeam) (*models.DeletedTeam, int, *Error) {
+	team, status, wErr := s.GetTeam(del.TeamName)
+	if wErr != nil {
+		return nil, status, wErr
+	}
+
+	if del.TargetTeamName == "" {
+		if len(team.Members) != 0 {
+			return nil, http.StatusConflict, &Error{Code: "TEAM_NOT_EMPTY", Message: "team has members, target_team_name required"}
+		}
+	} else {
+		if del.TargetTeamName == del.TeamName {
+			return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_TEAM_NAME", Message: "target team must differ"}
+		}
+		if _, status, wErr := s.GetTeam(del.TargetTeamName); wErr != nil {
+			return nil, status, wErr
+		}
+	}
+
+	moved, notFound, err := s.repo.DeleteTeam(del.TeamName, del.TargetTeamName)
+	if notFound {
+		s.l.Warnf("Team not found. teamName:%s", del.TeamName)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (delete team). Error: %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	if moved == nil {
+		moved = []models.User{}
+	}
+	return &models.DeletedTeam{TeamName: del.TeamName, MovedMembers: moved}, http.StatusOK, nil
+}
+
+func (s *TeamService) ReactivateTeam(req models.ReactivateTeam) (*models.ReactivatedTeam, int, *Error) {
+	var users []models.User
+	var restored []models.PrReviewer
+	var notFound bool
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		var err error
+		users, restored, notFound, err = tx.ReactivateTeam(req.TeamName, req.RestoreReviews)
+		if notFound || err != nil {
+			return err
+		}
+		events := make([]models.Event, 0, len(restored))
+		for _, r := range restored {
+			teamName, err := tx.GetPullRequestTeam(r.PullRequestID)
+			if err != nil {
+				return err
+			}
+			events = append(events, assignmentEvents(r.PullRequestID, teamName, []string{r.ReviewerID})...)
+		}
+		return tx.AddOutboxEvents(events)
+	})
+	if notFound {
+		return nil, http.StatusNotFound, &Error{Code: "TEAM_NOT_FOUND"}
+	}
+	if err != nil {
+		s.l.Errorf("Err in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	return &models.ReactivatedTeam{
+		TeamName:        req.TeamName,
+		Members:         users,
+		RestoredReviews: restored,
+	}, http.StatusOK, nil
+}
+
+func (s *TeamService) GetReviewSLA(teamName string) (*models.ReviewSLA, int, *Error) {
+	sla, notFound, err := s.repo.GetReviewSLA(teamName)
+	if notFound {
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (get review sla). Error: %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return sla, http.StatusOK, nil
+}
+
+// SetReviewSLA escalation по умолчанию reassign, для lead нужен lead_id
+func (s *TeamService) SetReviewSLA(sla models.ReviewSLA) (*models.ReviewSLA, int, *Error) {
+	remind, err := parseSLADuration(sla.RemindAfter)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_SLA", Message: "remind_after must be positive duration, e.g. 24h"}
+	}
+	escalate, err := parseSLADuration(sla.EscalateAfter)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_SLA", Message: "escalate_after must be positive duration, e.g. 72h"}
+	}
+	if remind == 0 && escalate == 0 {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_SLA", Message: "remind_after or escalate_after is required"}
+	}
+	if remind > 0 && escalate > 0 && escalate <= remind {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_SLA", Message: "escalate_after must be greater than remind_after"}
+	}
+	if sla.Escalation == "" {
+		sla.Escalation = "reassign"
+	}
+	if sla.LeadID != nil && *sla.LeadID == "" {
+		sla.LeadID = nil
+	}
+	switch {
+	case sla.Escalation != "lead" && sla.Escalation != "reassign":
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_ESCALATION", Message: "escalation must be lead or reassign"}
+	case sla.Escalation == "lead" && sla.LeadID == nil:
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_ESCALATION", Message: "lead_id is required for lead escalation"}
+	}
+
+	if _, status, wErr := s.GetTeam(sla.TeamName); wErr != nil {
+		return nil, status, wErr
+	}
+	if sla.LeadID != nil {
+		_, notFound, err := s.repo.GetUserByID(*sla.LeadID)
+		if notFound {
+			return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+		}
+		if err != nil {
+			s.l.Errorf("Error in DB (get user). Error: %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+	}
+
+	sla.UpdatedAt = time.Now()
+	if err := s.repo.SaveReviewSLA(&sla); err != nil {
+		s.l.Errorf("Error in DB (save review sla). Error: %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return &sla, http.StatusOK, nil
+}
+
+func (s *TeamService) DeleteReviewSLA(teamName string) (int, *Error) {
+	notFound, err := s.repo.DeleteReviewSLA(teamName)
+	if notFound {
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (delete review sla). Error: %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return http.StatusOK, nil
+}
+
+// parseSLADuration пусто - шаг выключен
+func parseSLADuration(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("duration must be positive")
+	}
+	return d, err
+}
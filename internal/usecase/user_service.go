@@ -5,16 +5,18 @@ import (
 	"github.com/ashurov-imomali/pr-service/internal/repository"
 	"github.com/ashurov-imomali/pr-service/pkg/logger"
 	"net/http"
+	"net/mail"
 	"strings"
 )
 
 type UserService struct {
 	repo repository.Repository
 	l    logger.Logger
+	m    DomainMetrics
 }
 
-func NewUserService(repo repository.Repository, l logger.Logger) *UserService {
-	return &UserService{repo: repo, l: l}
+func NewUserService(repo repository.Repository, l logger.Logger, m DomainMetrics) *UserService {
+	return &UserService{repo: repo, l: l, m: m}
 }
 
 func (s *UserService) UpdateUser(user models.User) (*models.User, int, *Error) {
@@ -22,37 +24,35 @@ func (s *UserService) UpdateUser(user models.User) (*models.User, int, *Error) {
 		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
 	}
 
-	review, notFound, err := s.repo.GetUsersReview(user.ID)
+	_, notFound, err := s.repo.GetUserByID(user.ID)
+	if notFound {
+		s.l.Warnf("User not found. userID: %s", user.ID)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
 	if err != nil {
 		s.l.Errorf("Error in bd. Err %v", err)
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
-	if user.IsActive == false && !notFound {
-		for _, pr := range review.PullRequests {
-			if pr.Status == "MERGED" {
-				continue
-			}
 
-			randomUser, notFound, err := s.repo.GetRandomUser(user.ID, pr.ID)
-			if err != nil {
-				s.l.Errorf("Error in bd. Err %v", err)
-				return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	// переназначение, события и сам флаг меняются вместе
+	var reassigned []models.Reassignment
+	err = s.repo.Transaction(func(tx repository.Repository) error {
+		var err error
+		if !user.IsActive {
+			if reassigned, err = reassignOpenReviews(tx, user.ID); err != nil {
+				return err
 			}
-			if notFound {
-				if err := s.repo.DeleteReviewer(user.ID, pr.ID); err != nil {
-					s.l.Errorf("Error in bd. Err %v", err)
-					return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
-				}
-				continue
+			events, err := reassignmentEvents(tx, reassigned)
+			if err != nil {
+				return err
 			}
-			if err := s.repo.UpdateReviewer(pr.ID, user.ID, randomUser); err != nil {
-				s.l.Errorf("Error in bd. Err %v", err)
-				return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+			if err := tx.AddOutboxEvents(events); err != nil {
+				return err
 			}
 		}
-	}
-
-	notFound, err = s.repo.UpdateUser(&user)
+		notFound, err = tx.UpdateUser(&user)
+		return err
+	})
 	if notFound {
 		s.l.Warnf("User not found. userID: %s", user.ID)
 		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
@@ -62,9 +62,46 @@ func (s *UserService) UpdateUser(user models.User) (*models.User, int, *Error) {
 		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
 	}
 
+	countNoCandidate(s.m, "deactivate", reassigned)
 	return &user, http.StatusOK, nil
 }
 
+// reassignOpenReviews переназначает открытые PR пользователя на другого участника команды.
+// Если кандидата нет, пользователь просто снимается с ревью
+func reassignOpenReviews(repo repository.Repository, userID string) ([]models.Reassignment, error) {
+	review, notFound, err := repo.GetUsersReview(userID)
+	if err != nil {
+		return nil, err
+	}
+	if notFound {
+		return nil, nil
+	}
+
+	var result []models.Reassignment
+	for _, pr := range review.PullRequests {
+		if pr.Status == "MERGED" {
+			continue
+		}
+
+		randomUser, notFound, err := repo.GetRandomUser(userID, pr.ID)
+		if err != nil {
+			return nil, err
+		}
+		if notFound {
+			if err := repo.DeleteReviewer(userID, pr.ID); err != nil {
+				return nil, err
+			}
+			result = append(result, models.Reassignment{PullRequestID: pr.ID, OldReviewerID: userID})
+			continue
+		}
+		if err := repo.UpdateReviewer(pr.ID, userID, randomUser); err != nil {
+			return nil, err
+		}
+		result = append(result, models.Reassignment{PullRequestID: pr.ID, OldReviewerID: userID, NewReviewerID: randomUser})
+	}
+	return result, nil
+}
+
 func (s *UserService) GetUsersReview(userID string) (*models.UsersReviews, int, *Error) {
 	reviews, _, err := s.repo.GetUsersReview(userID)
 	//if notFound {
@@ -76,3 +113,228 @@ func (s *UserService) GetUsersReview(userID string) (*models.UsersReviews, int,
 	}
 	return reviews, http.StatusOK, nil
 }
+
+func (s *UserService) GetUser(id string) (*models.User, int, *Error) {
+	user, notFound, err := s.repo.GetUserByID(id)
+	if notFound {
+		s.l.Warnf("User not found. userID: %s", id)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return user, http.StatusOK, nil
+}
+
+func (s *UserService) ListUsers(filter models.UserFilter) ([]models.User, int, *Error) {
+	users, err := s.repo.ListUsers(filter)
+	if err != nil {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return users, http.StatusOK, nil
+}
+
+func (s *UserService) CreateUser(user models.User) (*models.User, int, *Error) {
+	if len(strings.TrimSpace(user.ID)) == 0 {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_USER_ID"}
+	}
+	if len(strings.TrimSpace(user.Username)) == 0 {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_USERNAME"}
+	}
+
+	if user.AccountType == "" {
+		user.AccountType = "HUMAN"
+	}
+	if !validAccountType(user.AccountType) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_ACCOUNT_TYPE"}
+	}
+
+	if status, wErr := s.checkTeam(user.TeamName); wErr != nil {
+		return nil, status, wErr
+	}
+
+	_, notFound, err := s.repo.GetUserByID(user.ID)
+	if err != nil && !notFound {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if !notFound {
+		return nil, http.StatusConflict, &Error{Code: "USER_EXISTS", Message: "user_id already exists"}
+	}
+
+	if err := s.repo.CreateUser(&user); err != nil {
+		s.l.Errorf("Error in bd (create user). Err %v", err)
+		return nil, http.StatusConflict, &Error{Code: "USER_EXISTS", Message: "user_id already exists"}
+	}
+	return &user, http.StatusCreated, nil
+}
+
+func (s *UserService) UpdateUserInfo(info models.UpdateUserInfo) (*models.User, int, *Error) {
+	if len(strings.TrimSpace(info.ID)) == 0 {
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if info.Username != "" && len(strings.TrimSpace(info.Username)) == 0 {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_USERNAME"}
+	}
+	if info.AccountType != "" && !validAccountType(info.AccountType) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_ACCOUNT_TYPE"}
+	}
+
+	if info.TeamName != "" {
+		if status, wErr := s.checkTeam(info.TeamName); wErr != nil {
+			return nil, status, wErr
+		}
+	}
+
+	var user *models.User
+	var notFound bool
+	var reassigned []models.Reassignment
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		var err error
+		if user, notFound, reassigned, err = updateUserInfo(tx, info); err != nil || notFound {
+			return err
+		}
+		events, err := reassignmentEvents(tx, reassigned)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvents(events)
+	})
+	if notFound {
+		s.l.Warnf("User not found. userID: %s", info.ID)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (update user). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+
+	countNoCandidate(s.m, "bot", reassigned)
+	return user, http.StatusOK, nil
+}
+
+// updateUserInfo бот не ревьюит: при переводе в BOT открытые ревью переназначаются в той же транзакции
+func updateUserInfo(tx repository.Repository, info models.UpdateUserInfo) (*models.User, bool, []models.Reassignment, error) {
+	var reassigned []models.Reassignment
+	if info.AccountType == "BOT" {
+		current, notFound, err := tx.GetUserByID(info.ID)
+		if notFound {
+			return nil, true, nil, nil
+		}
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if current.AccountType != "BOT" {
+			if reassigned, err = reassignOpenReviews(tx, info.ID); err != nil {
+				return nil, false, nil, err
+			}
+		}
+	}
+	user, notFound, err := tx.UpdateUserInfo(info)
+	return user, notFound, reassigned, err
+}
+
+func (s *UserService) checkTeam(teamName string) (int, *Error) {
+	if len(strings.TrimSpace(teamName)) == 0 {
+		return http.StatusUnprocessableEntity, &Error{Code: "INVALID_TEAM_NAME"}
+	}
+	_, notFound, err := s.repo.GetTeam(teamName)
+	if notFound {
+		s.l.Warnf("Team not found. teamName:%s", teamName)
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in DB (get team). Error: %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *UserService) AddIdentity(identity models.UserIdentity) (*models.UserIdentity, int, *Error) {
+	identity.Provider, identity.ExternalID = normalizeIdentity(identity.Provider, identity.ExternalID)
+	if !validProvider(identity.Provider) {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_PROVIDER"}
+	}
+	if len(identity.ExternalID) == 0 {
+		return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_EXTERNAL_ID"}
+	}
+	// адрес уходит в RCPT TO как есть: ошибку лучше вернуть сейчас, а не тратить на нее попытки доставки
+	if identity.Provider == "email" {
+		if addr, err := mail.ParseAddress(identity.ExternalID); err != nil || addr.Address != identity.ExternalID {
+			return nil, http.StatusUnprocessableEntity, &Error{Code: "INVALID_EMAIL", Message: "external_id must be a plain email address"}
+		}
+	}
+
+	if _, status, wErr := s.GetUser(identity.UserID); wErr != nil {
+		return nil, status, wErr
+	}
+
+	_, notFound, err := s.repo.GetUserByIdentity(identity.Provider, identity.ExternalID)
+	if err != nil && !notFound {
+		s.l.Errorf("Error in bd. Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if !notFound {
+		return nil, http.StatusConflict, &Error{Code: "IDENTITY_EXISTS", Message: "identity already linked"}
+	}
+
+	if err := s.repo.AddIdentity(&identity); err != nil {
+		s.l.Errorf("Error in bd (add identity). Err %v", err)
+		return nil, http.StatusConflict, &Error{Code: "IDENTITY_EXISTS", Message: "identity already linked"}
+	}
+	return &identity, http.StatusCreated, nil
+}
+
+func (s *UserService) DeleteIdentity(identity models.ExternalIdentity) (int, *Error) {
+	provider, externalID := normalizeIdentity(identity.Provider, identity.ExternalID)
+	notFound, err := s.repo.DeleteIdentity(provider, externalID)
+	if notFound {
+		return http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (delete identity). Err %v", err)
+		return http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *UserService) GetIdentities(userID string) ([]models.UserIdentity, int, *Error) {
+	if _, status, wErr := s.GetUser(userID); wErr != nil {
+		return nil, status, wErr
+	}
+	identities, err := s.repo.GetIdentitiesByUserID(userID)
+	if err != nil {
+		s.l.Errorf("Error in bd (get identities). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return identities, http.StatusOK, nil
+}
+
+func (s *UserService) LookupIdentity(identity models.ExternalIdentity) (*models.User, int, *Error) {
+	user, notFound, err := resolveIdentity(s.repo, identity)
+	if notFound {
+		s.l.Warnf("identity not found: %+v", identity)
+		return nil, http.StatusNotFound, &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (get user by identity). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return user, http.StatusOK, nil
+}
+
+func resolveIdentity(repo repository.Repository, identity models.ExternalIdentity) (*models.User, bool, error) {
+	provider, externalID := normalizeIdentity(identity.Provider, identity.ExternalID)
+	return repo.GetUserByIdentity(provider, externalID)
+}
+
+// normalizeIdentity логины GitHub/GitLab и email не чувствительны к регистру
+func normalizeIdentity(provider, externalID string) (string, string) {
+	return strings.ToLower(strings.TrimSpace(provider)), strings.ToLower(strings.TrimSpace(externalID))
+}
+
+func validProvider(provider string) bool {
+	return provider == "github" || provider == "gitlab" || provider == "gitlab_id" || provider == "email"
+}
@@ -0,0 +1,28 @@
+package usecase
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+)
+
+func TestAddIdentityRejectsInvalidEmail(t *testing.T) {
+	s := NewUserService(newMemRepo(), logger.New(), nopMetrics{})
+	for _, email := range []string{
+		"u1",
+		"u1@",
+		"@example.com",
+		"u1@example.com, u2@example.com",
+		"U1 <u1@example.com>",
+		"u1@example.com\r\nBcc: u2@example.com",
+	} {
+		t.Run(email, func(t *testing.T) {
+			_, status, wErr := s.AddIdentity(models.UserIdentity{UserID: "u1", Provider: "email", ExternalID: email})
+			if status != http.StatusUnprocessableEntity || wErr == nil || wErr.Code != "INVALID_EMAIL" {
+				t.Fatalf("AddIdentity(%q) = %d, %+v", email, status, wErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ashurov-imomali/pr-service/internal/models"
+	"github.com/ashurov-imomali/pr-service/internal/repository"
+	"github.com/ashurov-imomali/pr-service/pkg/logger"
+	"net/http"
+	"strconv"
+)
+
+// WebhookService переводит входящие вебхуки (GitHub, GitLab) в операции PRService.
+// Доставка записывается в одной транзакции с изменением PR, поэтому после ошибки ее можно повторить
+type WebhookService struct {
+	repo repository.Repository
+	prs  *PRService
+	l    logger.Logger
+}
+
+func NewWebhookService(repo repository.Repository, prs *PRService, l logger.Logger) *WebhookService {
+	return &WebhookService{repo: repo, prs: prs, l: l}
+}
+
+// errWebhookRollback откатывает транзакцию доставки, ответ уже в status и wErr
+var errWebhookRollback = errors.New("webhook delivery rolled back")
+
+// HandleGitHub event - X-GitHub-Event, deliveryID - X-GitHub-Delivery. Подпись проверяется до вызова
+func (s *WebhookService) HandleGitHub(event, deliveryID string, payload []byte) (*models.WebhookDelivery, int, *Error) {
+	delivery := &models.WebhookDelivery{Provider: "github", DeliveryID: deliveryID, Event: event}
+	return s.process(delivery, func(s *WebhookService) (*models.WebhookDelivery, int, *Error) {
+		return s.handleGitHub(delivery, payload)
+	})
+}
+
+func (s *WebhookService) handleGitHub(delivery *models.WebhookDelivery, payload []byte) (*models.WebhookDelivery, int, *Error) {
+	switch delivery.Event {
+	case "pull_request":
+	case "ping":
+		return s.ignore(delivery, "ping")
+	default:
+		return s.ignore(delivery, "unsupported event")
+	}
+
+	var ev models.GitHubPullRequestEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_PAYLOAD", Message: "invalid pull_request payload"}
+	}
+	delivery.Action = ev.Action
+	delivery.PullRequestID = fmt.Sprintf("%s#%d", ev.Repository.FullName, ev.PullRequest.Number)
+
+	switch ev.Action {
+	case "opened", "reopened", "ready_for_review":
+		if ev.PullRequest.Draft {
+			return s.ignore(delivery, "draft")
+		}
+		return s.open(delivery, models.PullRequest{
+			ID:             delivery.PullRequestID,
+			Name:           ev.PullRequest.Title,
+			Repository:     ev.Repository.FullName,
+			AuthorIdentity: &models.ExternalIdentity{Provider: "github", ExternalID: ev.PullRequest.User.Login},
+		}, nil)
+	case "closed":
+		if !ev.PullRequest.Merged {
+			return s.ignore(delivery, "closed without merge")
+		}
+		return s.merge(delivery)
+	default:
+		return s.ignore(delivery, "unsupported action")
+	}
+}
+
+// HandleGitLab event - X-Gitlab-Event, deliveryID - Idempotency-Key (X-Gitlab-Event-UUID в старых версиях).
+// В payload нет username автора MR, только author_id: автор ищется по идентичности gitlab_id.
+// user - тот, кто вызвал событие, и автором он быть не обязан
+func (s *WebhookService) HandleGitLab(event, deliveryID string, payload []byte) (*models.WebhookDelivery, int, *Error) {
+	delivery := &models.WebhookDelivery{Provider: "gitlab", DeliveryID: deliveryID, Event: event}
+	return s.process(delivery, func(s *WebhookService) (*models.WebhookDelivery, int, *Error) {
+		return s.handleGitLab(delivery, payload)
+	})
+}
+
+func (s *WebhookService) handleGitLab(delivery *models.WebhookDelivery, payload []byte) (*models.WebhookDelivery, int, *Error) {
+	if delivery.Event != "Merge Request Hook" {
+		return s.ignore(delivery, "unsupported event")
+	}
+
+	var ev models.GitLabMergeRequestEvent
+	if err := json.Unmarshal(payload, &ev); err != nil || ev.ObjectKind != "merge_request" {
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_PAYLOAD", Message: "invalid merge_request payload"}
+	}
+	mr := ev.ObjectAttributes
+	delivery.Action = mr.Action
+	delivery.PullRequestID = fmt.Sprintf("%s!%d", ev.Project.PathWithNamespace, mr.IID)
+	draft := mr.Draft || mr.WorkInProgress
+
+	reviewers, err := s.gitlabUsers(ev.Reviewers)
+	if err != nil {
+		s.l.Errorf("Error in bd (get user by identity). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	pr := models.PullRequest{
+		ID:             delivery.PullRequestID,
+		Name:           mr.Title,
+		Repository:     ev.Project.PathWithNamespace,
+		AuthorIdentity: &models.ExternalIdentity{Provider: "gitlab_id", ExternalID: strconv.Itoa(mr.AuthorID)},
+	}
+
+	switch mr.Action {
+	case "open", "reopen":
+		if draft {
+			return s.ignore(delivery, "draft")
+		}
+		return s.open(delivery, pr, reviewers)
+	case "update":
+		existing, notFound, err := s.repo.GetPullRequestByID(pr.ID)
+		if err != nil && !notFound {
+			s.l.Errorf("Error in bd (get pr by id). Err %v", err)
+			return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+		}
+		switch {
+		case notFound && mr.State != "opened":
+			return s.ignore(delivery, "unknown pull request")
+		case notFound && draft:
+			return s.ignore(delivery, "draft")
+		case notFound:
+			// MR вышел из draft
+			return s.open(delivery, pr, reviewers)
+		case len(ev.Changes.Reviewers) == 0:
+			return s.ignore(delivery, "no relevant changes")
+		case existing.Status != "OPEN":
+			return s.ignore(delivery, "pull request already "+existing.Status)
+		case len(reviewers) == 0:
+			return s.ignore(delivery, "no known reviewers")
+		}
+		if _, status, wErr := s.prs.SetReviewers(pr.ID, reviewers); wErr != nil {
+			return nil, status, wErr
+		}
+		return s.save(delivery, "PROCESSED", "")
+	case "merge":
+		return s.merge(delivery)
+	case "close":
+		return s.ignore(delivery, "closed without merge")
+	default:
+		return s.ignore(delivery, "unsupported action")
+	}
+}
+
+// gitlabUsers внутренние ID по username GitLab, непривязанные пропускаются
+func (s *WebhookService) gitlabUsers(users []models.GitLabUser) ([]string, error) {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		user, notFound, err := resolveIdentity(s.repo, models.ExternalIdentity{Provider: "gitlab", ExternalID: u.Username})
+		if notFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids, nil
+}
+
+// process доставка записывается первой, в одной транзакции с изменением PR. Повторная доставка получает
+// сохраненный результат, при ошибке обработки откатывается и запись, и изменение PR
+func (s *WebhookService) process(delivery *models.WebhookDelivery, handle func(s *WebhookService) (*models.WebhookDelivery, int, *Error)) (*models.WebhookDelivery, int, *Error) {
+	if delivery.DeliveryID == "" {
+		return nil, http.StatusBadRequest, &Error{Code: "INVALID_DELIVERY", Message: "delivery id is required"}
+	}
+
+	var result *models.WebhookDelivery
+	var status int
+	var wErr *Error
+	err := s.repo.Transaction(func(tx repository.Repository) error {
+		// статус уточняется в save, до коммита запись никто не видит
+		claim := *delivery
+		claim.Status = "PROCESSED"
+		claimed, err := tx.ClaimWebhookDelivery(&claim)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			saved, _, err := tx.GetWebhookDelivery(delivery.Provider, delivery.DeliveryID)
+			if err != nil {
+				return err
+			}
+			saved.Duplicate = true
+			result, status = saved, http.StatusOK
+			return nil
+		}
+		delivery.CreatedAt = claim.CreatedAt
+
+		ws := &WebhookService{repo: tx, prs: &PRService{repo: tx, l: s.prs.l, m: s.prs.m}, l: s.l}
+		if result, status, wErr = handle(ws); wErr != nil {
+			return errWebhookRollback
+		}
+		return nil
+	})
+	if wErr != nil {
+		return nil, status, wErr
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (webhook delivery). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return result, status, nil
+}
+
+// open PR, который уже есть (повторный opened/reopened), не считается ошибкой.
+// reviewerIDs пустой - ревьюверы назначаются как обычно
+func (s *WebhookService) open(delivery *models.WebhookDelivery, pr models.PullRequest, reviewerIDs []string) (*models.WebhookDelivery, int, *Error) {
+	existing, notFound, err := s.repo.GetPullRequestByID(pr.ID)
+	if err != nil && !notFound {
+		s.l.Errorf("Error in bd (get pr by id). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if !notFound {
+		return s.ignore(delivery, "pull request already "+existing.Status)
+	}
+
+	if _, status, wErr := s.prs.CreatePullRequestWithReviewers(pr, reviewerIDs); wErr != nil {
+		s.l.Warnf("Webhook %s %s: create PR failed %+v", delivery.Provider, delivery.DeliveryID, wErr)
+		return nil, status, wErr
+	}
+	return s.save(delivery, "PROCESSED", "")
+}
+
+// merge повторный merge уже смерженного PR ничего не меняет
+func (s *WebhookService) merge(delivery *models.WebhookDelivery) (*models.WebhookDelivery, int, *Error) {
+	existing, notFound, err := s.repo.GetPullRequestByID(delivery.PullRequestID)
+	if notFound {
+		return s.ignore(delivery, "unknown pull request")
+	}
+	if err != nil {
+		s.l.Errorf("Error in bd (get pr by id). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	if existing.Status == "MERGED" {
+		return s.ignore(delivery, "pull request already MERGED")
+	}
+
+	_, status, wErr := s.prs.MergePullRequest(models.PullRequest{ID: delivery.PullRequestID})
+	if wErr != nil {
+		if wErr.Code == "NOT_FOUND" {
+			return s.ignore(delivery, "unknown pull request")
+		}
+		return nil, status, wErr
+	}
+	return s.save(delivery, "PROCESSED", "")
+}
+
+func (s *WebhookService) ignore(delivery *models.WebhookDelivery, reason string) (*models.WebhookDelivery, int, *Error) {
+	return s.save(delivery, "IGNORED", reason)
+}
+
+func (s *WebhookService) save(delivery *models.WebhookDelivery, status, reason string) (*models.WebhookDelivery, int, *Error) {
+	delivery.Status, delivery.Reason = status, reason
+	if err := s.repo.UpdateWebhookDelivery(delivery); err != nil {
+		s.l.Errorf("Error in bd (save webhook delivery). Err %v", err)
+		return nil, http.StatusInternalServerError, &Error{Code: "INTERNAL_SERVER_ERROR"}
+	}
+	return delivery, http.StatusOK, nil
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+alter table users drop constraint users_team_name_fkey;
+alter table users
+    add constraint users_team_name_fkey foreign key (team_name)
+        references teams (name) on update cascade;
+
+-- +goose Down
+alter table users drop constraint users_team_name_fkey;
+alter table users
+    add constraint users_team_name_fkey foreign key (team_name)
+        references teams (name);
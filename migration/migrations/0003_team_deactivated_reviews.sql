@@ -0,0 +1,12 @@
+-- +goose Up
+-- Назначения, снятые при деактивации команды. Нужны для восстановления при реактивации
+create table team_deactivated_reviews (
+    team_name text not null references teams(name) on update cascade on delete cascade,
+    pull_request_id text not null references pull_requests(id),
+    reviewer_id text not null references users(id),
+    deactivated_at timestamptz not null default now(),
+    unique (pull_request_id, reviewer_id)
+);
+
+-- +goose Down
+drop table team_deactivated_reviews;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- BOT может быть автором PR, но никогда не назначается ревьювером
+alter table users
+    add column account_type text not null default 'HUMAN' check (account_type in ('HUMAN', 'BOT'));
+
+-- +goose Down
+alter table users drop column account_type;
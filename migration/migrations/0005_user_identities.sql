@@ -0,0 +1,14 @@
+-- +goose Up
+-- Внешние идентичности пользователя (логин GitHub, username GitLab, email)
+create table user_identities (
+    provider text not null check (provider in ('github', 'gitlab', 'email')),
+    external_id text not null,
+    user_id text not null references users(id) on delete cascade,
+    created_at timestamptz not null default now(),
+    primary key (provider, external_id)
+);
+
+create index user_identities_user_id_idx on user_identities (user_id);
+
+-- +goose Down
+drop table user_identities;
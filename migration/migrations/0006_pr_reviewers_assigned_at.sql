@@ -0,0 +1,17 @@
+-- +goose Up
+alter table pr_reviewers
+    add column assigned_at timestamptz not null default now();
+
+-- для существующих назначений точного времени нет, берем время создания PR
+update pr_reviewers p
+set assigned_at = pr.created_at
+from pull_requests pr
+where pr.id = p.pull_request_id;
+
+create index pr_reviewers_reviewer_assigned_idx on pr_reviewers (reviewer_id, assigned_at);
+create index pull_requests_created_at_idx on pull_requests (created_at);
+
+-- +goose Down
+drop index pull_requests_created_at_idx;
+drop index pr_reviewers_reviewer_assigned_idx;
+alter table pr_reviewers drop column assigned_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+alter table pull_requests
+    add column repository text not null default '';
+
+-- Вердикт ревьювера. reviewed_at - время первого вердикта, verdict - последний
+alter table pr_reviewers
+    add column verdict text check (verdict in ('APPROVED', 'CHANGES_REQUESTED', 'COMMENTED')),
+    add column reviewed_at timestamptz;
+
+create index pull_requests_merged_at_idx on pull_requests (merged_at);
+
+-- +goose Down
+drop index pull_requests_merged_at_idx;
+alter table pr_reviewers
+    drop column reviewed_at,
+    drop column verdict;
+alter table pull_requests drop column repository;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- для gauge открытых PR в /metrics
+create index pull_requests_open_idx on pull_requests (id) where status = 'OPEN';
+
+-- +goose Down
+drop index pull_requests_open_idx;
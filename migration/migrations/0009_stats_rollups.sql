@@ -0,0 +1,242 @@
+-- +goose Up
+-- Дневные агрегаты для статистики. Обновляются триггерами в той же транзакции,
+-- что и изменения pull_requests / pr_reviewers / users. День считается в UTC.
+--   prs_created, prs_open      - PR автора по дню создания (prs_open - еще не смерженные)
+--   prs_merged                 - PR автора по дню merge
+--   reviews_*                  - назначения ревьювера по дню назначения и статусу PR
+create table stats_user_daily (
+    user_id text not null references users(id),
+    day date not null,
+    prs_created int not null default 0,
+    prs_open int not null default 0,
+    prs_merged int not null default 0,
+    reviews_assigned int not null default 0,
+    reviews_open int not null default 0,
+    reviews_merged int not null default 0,
+    primary key (user_id, day)
+);
+
+-- Сумма stats_user_daily по текущим участникам команды
+create table stats_team_daily (
+    team_name text not null,
+    day date not null,
+    prs_created int not null default 0,
+    prs_open int not null default 0,
+    prs_merged int not null default 0,
+    reviews_assigned int not null default 0,
+    reviews_open int not null default 0,
+    reviews_merged int not null default 0,
+    primary key (team_name, day)
+);
+
+create index stats_user_daily_day_idx on stats_user_daily (day);
+create index stats_team_daily_day_idx on stats_team_daily (day);
+
+-- +goose StatementBegin
+create function stats_bump(p_user text, p_day date,
+                           p_created int, p_open int, p_merged int,
+                           p_assigned int, p_reviews_open int, p_reviews_merged int) returns void as
+$$
+declare
+    v_team text;
+begin
+    if p_user is null or p_day is null then
+        return;
+    end if;
+
+    insert into stats_user_daily as s (user_id, day, prs_created, prs_open, prs_merged,
+                                       reviews_assigned, reviews_open, reviews_merged)
+    values (p_user, p_day, p_created, p_open, p_merged, p_assigned, p_reviews_open, p_reviews_merged)
+    on conflict (user_id, day) do update
+        set prs_created      = s.prs_created + excluded.prs_created,
+            prs_open         = s.prs_open + excluded.prs_open,
+            prs_merged       = s.prs_merged + excluded.prs_merged,
+            reviews_assigned = s.reviews_assigned + excluded.reviews_assigned,
+            reviews_open     = s.reviews_open + excluded.reviews_open,
+            reviews_merged   = s.reviews_merged + excluded.reviews_merged;
+
+    select team_name into v_team from users where id = p_user;
+    if v_team is null then
+        return;
+    end if;
+
+    insert into stats_team_daily as s (team_name, day, prs_created, prs_open, prs_merged,
+                                       reviews_assigned, reviews_open, reviews_merged)
+    values (v_team, p_day, p_created, p_open, p_merged, p_assigned, p_reviews_open, p_reviews_merged)
+    on conflict (team_name, day) do update
+        set prs_created      = s.prs_created + excluded.prs_created,
+            prs_open         = s.prs_open + excluded.prs_open,
+            prs_merged       = s.prs_merged + excluded.prs_merged,
+            reviews_assigned = s.reviews_assigned + excluded.reviews_assigned,
+            reviews_open     = s.reviews_open + excluded.reviews_open,
+            reviews_merged   = s.reviews_merged + excluded.reviews_merged;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+create function stats_pull_requests_trg() returns trigger as
+$$
+declare
+    r record;
+begin
+    if tg_op in ('UPDATE', 'DELETE') then
+        perform stats_bump(old.author_id, (old.created_at at time zone 'UTC')::date,
+                           -1, -(old.status = 'OPEN')::int, 0, 0, 0, 0);
+        if old.status = 'MERGED' and old.merged_at is not null then
+            perform stats_bump(old.author_id, (old.merged_at at time zone 'UTC')::date, 0, 0, -1, 0, 0, 0);
+        end if;
+    end if;
+
+    if tg_op in ('INSERT', 'UPDATE') then
+        perform stats_bump(new.author_id, (new.created_at at time zone 'UTC')::date,
+                           1, (new.status = 'OPEN')::int, 0, 0, 0, 0);
+        if new.status = 'MERGED' and new.merged_at is not null then
+            perform stats_bump(new.author_id, (new.merged_at at time zone 'UTC')::date, 0, 0, 1, 0, 0, 0);
+        end if;
+    end if;
+
+    -- смена статуса переносит назначения из open в merged
+    if tg_op = 'UPDATE' and old.status is distinct from new.status then
+        for r in select reviewer_id, assigned_at from pr_reviewers where pull_request_id = new.id
+            loop
+                perform stats_bump(r.reviewer_id, (r.assigned_at at time zone 'UTC')::date, 0, 0, 0, 0,
+                                   (new.status = 'OPEN')::int - (old.status = 'OPEN')::int,
+                                   (new.status = 'MERGED')::int - (old.status = 'MERGED')::int);
+            end loop;
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+create function stats_pr_reviewers_trg() returns trigger as
+$$
+declare
+    v_status text;
+begin
+    if tg_op in ('UPDATE', 'DELETE') then
+        select status into v_status from pull_requests where id = old.pull_request_id;
+        perform stats_bump(old.reviewer_id, (old.assigned_at at time zone 'UTC')::date, 0, 0, 0,
+                           -1, -(v_status = 'OPEN')::int, -(v_status = 'MERGED')::int);
+    end if;
+
+    if tg_op in ('INSERT', 'UPDATE') then
+        select status into v_status from pull_requests where id = new.pull_request_id;
+        perform stats_bump(new.reviewer_id, (new.assigned_at at time zone 'UTC')::date, 0, 0, 0,
+                           1, (v_status = 'OPEN')::int, (v_status = 'MERGED')::int);
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- при переходе пользователя в другую команду (в т.ч. каскадно при переименовании) его агрегаты переезжают вместе с ним
+create function stats_users_trg() returns trigger as
+$$
+begin
+    insert into stats_team_daily as t (team_name, day, prs_created, prs_open, prs_merged,
+                                       reviews_assigned, reviews_open, reviews_merged)
+    select new.team_name, day, prs_created, prs_open, prs_merged, reviews_assigned, reviews_open, reviews_merged
+    from stats_user_daily
+    where user_id = new.id
+    on conflict (team_name, day) do update
+        set prs_created      = t.prs_created + excluded.prs_created,
+            prs_open         = t.prs_open + excluded.prs_open,
+            prs_merged       = t.prs_merged + excluded.prs_merged,
+            reviews_assigned = t.reviews_assigned + excluded.reviews_assigned,
+            reviews_open     = t.reviews_open + excluded.reviews_open,
+            reviews_merged   = t.reviews_merged + excluded.reviews_merged;
+
+    update stats_team_daily t
+    set prs_created      = t.prs_created - s.prs_created,
+        prs_open         = t.prs_open - s.prs_open,
+        prs_merged       = t.prs_merged - s.prs_merged,
+        reviews_assigned = t.reviews_assigned - s.reviews_assigned,
+        reviews_open     = t.reviews_open - s.reviews_open,
+        reviews_merged   = t.reviews_merged - s.reviews_merged
+    from stats_user_daily s
+    where s.user_id = new.id
+      and t.team_name = old.team_name
+      and t.day = s.day;
+
+    delete from stats_team_daily
+    where team_name = old.team_name
+      and prs_created = 0 and prs_open = 0 and prs_merged = 0
+      and reviews_assigned = 0 and reviews_open = 0 and reviews_merged = 0;
+    return null;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+create function stats_rebuild() returns void as
+$$
+begin
+    lock table pull_requests, pr_reviewers, users in share mode;
+    delete from stats_team_daily;
+    delete from stats_user_daily;
+
+    insert into stats_user_daily (user_id, day, prs_created, prs_open, prs_merged,
+                                  reviews_assigned, reviews_open, reviews_merged)
+    select user_id, day, sum(c), sum(o), sum(m), sum(a), sum(ro), sum(rm)
+    from (select author_id user_id, (created_at at time zone 'UTC')::date day,
+                 1 c, (status = 'OPEN')::int o, 0 m, 0 a, 0 ro, 0 rm
+          from pull_requests
+          union all
+          select author_id, (merged_at at time zone 'UTC')::date, 0, 0, 1, 0, 0, 0
+          from pull_requests
+          where status = 'MERGED' and merged_at is not null
+          union all
+          select p.reviewer_id, (p.assigned_at at time zone 'UTC')::date, 0, 0, 0, 1,
+                 (pr.status = 'OPEN')::int, (pr.status = 'MERGED')::int
+          from pr_reviewers p
+          join pull_requests pr on pr.id = p.pull_request_id
+          where p.reviewer_id is not null) e
+    group by user_id, day;
+
+    insert into stats_team_daily (team_name, day, prs_created, prs_open, prs_merged,
+                                  reviews_assigned, reviews_open, reviews_merged)
+    select u.team_name, s.day, sum(s.prs_created), sum(s.prs_open), sum(s.prs_merged),
+           sum(s.reviews_assigned), sum(s.reviews_open), sum(s.reviews_merged)
+    from stats_user_daily s
+    join users u on u.id = s.user_id
+    group by u.team_name, s.day;
+end;
+$$ language plpgsql;
+-- +goose StatementEnd
+
+create trigger stats_pull_requests
+    after insert or update of status, merged_at, created_at, author_id or delete
+    on pull_requests
+    for each row
+execute function stats_pull_requests_trg();
+
+create trigger stats_pr_reviewers
+    after insert or update of reviewer_id, assigned_at, pull_request_id or delete
+    on pr_reviewers
+    for each row
+execute function stats_pr_reviewers_trg();
+
+create trigger stats_users
+    after update of team_name
+    on users
+    for each row
+    when (old.team_name is distinct from new.team_name)
+execute function stats_users_trg();
+
+select stats_rebuild();
+
+-- +goose Down
+drop trigger stats_users on users;
+drop trigger stats_pr_reviewers on pr_reviewers;
+drop trigger stats_pull_requests on pull_requests;
+drop function stats_rebuild();
+drop function stats_users_trg();
+drop function stats_pr_reviewers_trg();
+drop function stats_pull_requests_trg();
+drop function stats_bump(text, date, int, int, int, int, int, int);
+drop table stats_team_daily;
+drop table stats_user_daily;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Обработанные входящие вебхуки, для дедупликации повторных доставок
+create table webhook_deliveries (
+    provider text not null,
+    delivery_id text not null,
+    event text not null,
+    action text not null default '',
+    pull_request_id text not null default '',
+    status text not null check (status in ('PROCESSED', 'IGNORED')),
+    reason text not null default '',
+    created_at timestamptz not null default now(),
+    primary key (provider, delivery_id)
+);
+
+-- +goose Down
+drop table webhook_deliveries;
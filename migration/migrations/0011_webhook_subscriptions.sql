@@ -0,0 +1,31 @@
+-- +goose Up
+-- Подписки на исходящие вебхуки. events - json массив типов событий, пустой - все
+create table webhook_subscriptions (
+    id bigserial primary key,
+    url text not null,
+    secret text not null,
+    events jsonb not null default '[]',
+    created_at timestamptz not null default now()
+);
+
+-- Журнал доставок: одна строка на пару (событие, подписка), с повторами по next_attempt_at
+create table subscription_deliveries (
+    id bigserial primary key,
+    subscription_id bigint not null references webhook_subscriptions(id) on delete cascade,
+    event_type text not null,
+    payload jsonb not null,
+    status text not null default 'PENDING' check (status in ('PENDING', 'SUCCEEDED', 'FAILED')),
+    attempts int not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error text not null default '',
+    response_status int,
+    created_at timestamptz not null default now(),
+    delivered_at timestamptz
+);
+
+create index subscription_deliveries_due_idx on subscription_deliveries (next_attempt_at) where status = 'PENDING';
+create index subscription_deliveries_subscription_idx on subscription_deliveries (subscription_id, id);
+
+-- +goose Down
+drop table subscription_deliveries;
+drop table webhook_subscriptions;
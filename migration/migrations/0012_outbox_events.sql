@@ -0,0 +1,23 @@
+-- +goose Up
+-- Outbox: события пишутся в одной транзакции с изменением и отправляются релеем.
+-- ordering_key - PR (или команда для team.deactivated), внутри ключа события уходят строго по id
+create table outbox_events (
+    id bigserial primary key,
+    type text not null,
+    pull_request_id text not null default '',
+    team_name text not null default '',
+    user_ids jsonb not null default '[]',
+    occurred_at timestamptz not null default now(),
+    data jsonb not null,
+    ordering_key text not null,
+    attempts int not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error text not null default '',
+    delivered_at timestamptz
+);
+
+create index outbox_events_pending_idx on outbox_events (ordering_key, id) where delivered_at is null;
+create index outbox_events_delivered_idx on outbox_events (delivered_at) where delivered_at is not null;
+
+-- +goose Down
+drop table outbox_events;
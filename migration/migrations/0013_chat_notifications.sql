@@ -0,0 +1,48 @@
+-- +goose Up
+-- Incoming webhook чата (Slack-совместимый) для команды или для конкретного пользователя
+create table chat_webhooks (
+    id bigserial primary key,
+    team_name text references teams(name) on update cascade on delete cascade,
+    user_id text references users(id) on delete cascade,
+    url text not null,
+    created_at timestamptz not null default now(),
+    check ((team_name is null) <> (user_id is null))
+);
+
+create unique index chat_webhooks_team_idx on chat_webhooks (team_name) where team_name is not null;
+create unique index chat_webhooks_user_idx on chat_webhooks (user_id) where user_id is not null;
+
+-- Настройки уведомлений пользователя. Тихие часы - "HH:MM" в timezone, интервал может переходить через полночь
+create table notification_preferences (
+    user_id text primary key references users(id) on delete cascade,
+    quiet_start text not null default '',
+    quiet_end text not null default '',
+    timezone text not null default 'UTC',
+    updated_at timestamptz not null default now()
+);
+
+-- Очередь уведомлений. Одно событие дает не больше одного уведомления пользователю в канале,
+-- поэтому повторная отправка события из outbox не дублирует сообщения
+create table notifications (
+    id bigserial primary key,
+    channel text not null,
+    user_id text not null references users(id) on delete cascade,
+    target text not null,
+    event_id bigint not null,
+    event_type text not null,
+    body text not null,
+    status text not null default 'PENDING' check (status in ('PENDING', 'SENT', 'FAILED')),
+    attempts int not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error text not null default '',
+    created_at timestamptz not null default now(),
+    sent_at timestamptz,
+    unique (channel, user_id, event_id)
+);
+
+create index notifications_due_idx on notifications (channel, next_attempt_at) where status = 'PENDING';
+
+-- +goose Down
+drop table notifications;
+drop table notification_preferences;
+drop table chat_webhooks;
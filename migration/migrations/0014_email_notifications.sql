@@ -0,0 +1,13 @@
+-- +goose Up
+-- Тема письма для канала email. Дайджест - event_type 'digest', event_id - дата yyyymmdd,
+-- поэтому дайджест за день создается один раз даже при нескольких репликах
+alter table notifications add column subject text not null default '';
+
+alter table notifications drop constraint notifications_channel_user_id_event_id_key;
+alter table notifications add constraint notifications_dedupe_key unique (channel, user_id, event_type, event_id);
+
+-- +goose Down
+alter table notifications drop constraint notifications_dedupe_key;
+delete from notifications where event_type = 'digest';
+alter table notifications add constraint notifications_channel_user_id_event_id_key unique (channel, user_id, event_id);
+alter table notifications drop column subject;
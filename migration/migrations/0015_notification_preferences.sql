@@ -0,0 +1,13 @@
+-- +goose Up
+-- Каналы (chat, email; пустой список - никаких уведомлений), типы событий, о которых уведомлять,
+-- и частота дайджеста. Значения по умолчанию - поведение до появления настроек
+alter table notification_preferences
+    add column channels jsonb not null default '["chat", "email"]',
+    add column event_types jsonb not null default '["pr.reviewer_assigned", "pr.reviewer_replaced", "pr.merged"]',
+    add column digest_frequency text not null default 'daily' check (digest_frequency in ('daily', 'weekly', 'off'));
+
+-- +goose Down
+alter table notification_preferences
+    drop column digest_frequency,
+    drop column event_types,
+    drop column channels;
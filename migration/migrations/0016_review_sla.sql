@@ -0,0 +1,36 @@
+-- +goose Up
+-- SLA ревью команды автора PR. remind_after, escalate_after - длительности Go ("24h"), пусто - шаг выключен.
+-- escalation: lead - уведомить lead_id, reassign - переназначить ревьювера
+create table review_slas (
+    team_name text primary key references teams(name) on update cascade on delete cascade,
+    remind_after text not null default '',
+    escalate_after text not null default '',
+    escalation text not null default 'reassign' check (escalation in ('lead', 'reassign')),
+    lead_id text references users(id) on delete set null,
+    updated_at timestamptz not null default now()
+);
+
+-- Сработавшие напоминания и эскалации. Ключ - назначение (PR, ревьювер, время назначения) и шаг,
+-- поэтому при нескольких репликах каждый шаг срабатывает один раз, а после переназначения отсчет идет заново
+create table sla_actions (
+    pull_request_id text not null references pull_requests(id) on delete cascade,
+    reviewer_id text not null,
+    assigned_at timestamptz not null,
+    stage text not null check (stage in ('reminder', 'escalation')),
+    created_at timestamptz not null default now(),
+    primary key (pull_request_id, reviewer_id, assigned_at, stage)
+);
+
+-- новые типы уведомлений включены и у тех, кто уже сохранил настройки
+update notification_preferences
+set event_types = event_types || '["pr.review_reminder", "pr.review_escalated"]';
+alter table notification_preferences alter column event_types
+    set default '["pr.reviewer_assigned", "pr.reviewer_replaced", "pr.merged", "pr.review_reminder", "pr.review_escalated"]';
+
+-- +goose Down
+alter table notification_preferences alter column event_types
+    set default '["pr.reviewer_assigned", "pr.reviewer_replaced", "pr.merged"]';
+update notification_preferences
+set event_types = event_types - 'pr.review_reminder' - 'pr.review_escalated';
+drop table sla_actions;
+drop table review_slas;
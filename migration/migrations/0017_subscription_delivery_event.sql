@@ -0,0 +1,18 @@
+-- +goose Up
+-- event_id - id события outbox. Релей повторяет событие всем получателям, если один из них упал,
+-- повтор не должен ставить подписке вторую доставку. У старых повторов event_id остается пустым
+alter table subscription_deliveries add column event_id bigint;
+
+update subscription_deliveries d
+set event_id = (d.payload->>'id')::bigint
+where d.id in (
+    select min(id) from subscription_deliveries
+    where payload ? 'id'
+    group by subscription_id, payload->>'id'
+);
+
+alter table subscription_deliveries
+    add constraint subscription_deliveries_event_key unique (subscription_id, event_id);
+
+-- +goose Down
+alter table subscription_deliveries drop column event_id;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SKIPPED - получатель отключил канал или тип события, пока уведомление ждало в очереди
+alter table notifications drop constraint notifications_status_check;
+alter table notifications add constraint notifications_status_check
+    check (status in ('PENDING', 'SENT', 'FAILED', 'SKIPPED'));
+
+-- +goose Down
+delete from notifications where status = 'SKIPPED';
+alter table notifications drop constraint notifications_status_check;
+alter table notifications add constraint notifications_status_check
+    check (status in ('PENDING', 'SENT', 'FAILED'));
@@ -0,0 +1,11 @@
+-- +goose Up
+-- gitlab_id - числовой id пользователя GitLab, отдельно от username (gitlab), чтобы они не пересекались
+alter table user_identities drop constraint user_identities_provider_check;
+alter table user_identities add constraint user_identities_provider_check
+    check (provider in ('github', 'gitlab', 'gitlab_id', 'email'));
+
+-- +goose Down
+delete from user_identities where provider = 'gitlab_id';
+alter table user_identities drop constraint user_identities_provider_check;
+alter table user_identities add constraint user_identities_provider_check
+    check (provider in ('github', 'gitlab', 'email'));
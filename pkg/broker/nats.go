@@ -0,0 +1,16 @@
+package broker
+
+import (
+	"github.com/nats-io/nats.go"
+	"time"
+)
+
+// New подключение к NATS с бесконечным переподключением: сообщения, опубликованные
+// во время обрыва, копятся в буфере клиента
+func New(url, name string) (*nats.Conn, error) {
+	return nats.Connect(url,
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+	)
+}
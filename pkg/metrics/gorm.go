@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const startKey = "metrics:start"
+
+// GormPlugin замеряет время запросов через callbacks gorm
+type GormPlugin struct {
+	m *Metrics
+}
+
+func (m *Metrics) GormPlugin() *GormPlugin {
+	return &GormPlugin{m: m}
+}
+
+func (p *GormPlugin) Name() string {
+	return "metrics"
+}
+
+func (p *GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	register := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+
+	for _, r := range register {
+		operation := r.operation
+		if err := r.before("metrics:before_"+operation, before); err != nil {
+			return err
+		}
+		if err := r.after("metrics:after_"+operation, func(db *gorm.DB) {
+			p.after(operation, db)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *GormPlugin) after(operation string, db *gorm.DB) {
+	v, ok := db.InstanceGet(startKey)
+	if !ok {
+		return
+	}
+	start, ok := v.(time.Time)
+	if !ok {
+		return
+	}
+
+	table := db.Statement.Table
+	if table == "" {
+		table = "raw"
+	}
+	p.m.ObserveQuery(operation, table, time.Since(start))
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	dbDuration   *prometheus.HistogramVec
+	openPRs      prometheus.Gauge
+	openReviews  *prometheus.GaugeVec
+	noCandidate  *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pr_service_http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pr_service_http_request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pr_service_db_query_duration_seconds",
+			Help:    "Database query latency by operation and table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "table"}),
+		openPRs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pr_service_open_pull_requests",
+			Help: "Pull requests in OPEN status.",
+		}),
+		openReviews: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pr_service_user_open_reviews",
+			Help: "Open pull requests assigned to a reviewer.",
+		}, []string{"user_id"}),
+		noCandidate: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pr_service_no_candidate_total",
+			Help: "Reassignments without an active replacement candidate.",
+		}, []string{"source"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.dbDuration,
+		m.openPRs,
+		m.openReviews,
+		m.noCandidate,
+	)
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware route берется из r.Pattern, который выставляет ServeMux, поэтому
+// в метках только маршруты из RegisterRouters, а не произвольные пути
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		m.httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *Metrics) ObserveQuery(operation, table string, d time.Duration) {
+	m.dbDuration.WithLabelValues(operation, table).Observe(d.Seconds())
+}
+
+func (m *Metrics) NoCandidate(source string) {
+	m.noCandidate.WithLabelValues(source).Inc()
+}
+
+func (m *Metrics) SetOpenPRs(count int64) {
+	m.openPRs.Set(float64(count))
+}
+
+func (m *Metrics) SetOpenReviews(counts map[string]int64) {
+	m.openReviews.Reset()
+	for userID, count := range counts {
+		m.openReviews.WithLabelValues(userID).Set(float64(count))
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}